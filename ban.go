@@ -0,0 +1,113 @@
+package gcse
+
+import (
+	"encoding/gob"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BanEntry is an entry of the ban list. A banned package is removed from the
+// docs and never crawled or indexed again.
+type BanEntry struct {
+	// whether the packages under the path are banned as well
+	Prefix bool
+	Reason string
+	Added  time.Time
+}
+
+func init() {
+	gob.Register(BanEntry{})
+}
+
+// the loaded ban list, key: package or import prefix
+var banList struct {
+	sync.RWMutex
+	m map[string]BanEntry
+}
+
+func registerBan(path string, ent BanEntry) {
+	banList.Lock()
+	defer banList.Unlock()
+
+	if banList.m == nil {
+		banList.m = make(map[string]BanEntry)
+	}
+	banList.m[path] = ent
+}
+
+// LoadBanList loads the ban list in a db, i.e. the one of KindBan in
+// CrawlerDBPath, for IsBanned.
+func LoadBanList(db *MemDB) {
+	m := make(map[string]BanEntry)
+	db.Iterate(func(path string, val interface{}) error {
+		if ent, ok := val.(BanEntry); ok {
+			m[path] = ent
+		}
+		return nil
+	})
+	banList.Lock()
+	banList.m = m
+	banList.Unlock()
+	log.Printf("%d entries of the ban list loaded", len(m))
+}
+
+// banOf returns the path and the BanEntry banning a package in the loaded ban
+// list. Returns "" if not banned.
+func banOf(pkg string) (string, BanEntry) {
+	banList.RLock()
+	defer banList.RUnlock()
+
+	if len(banList.m) == 0 {
+		return "", BanEntry{}
+	}
+	for _, p := range vanityPrefixes(pkg) {
+		if ent, ok := banList.m[p]; ok && (p == pkg || ent.Prefix) {
+			return p, ent
+		}
+	}
+	return "", BanEntry{}
+}
+
+// IsBanned returns whether a package is banned by the loaded ban list.
+func IsBanned(pkg string) bool {
+	path, _ := banOf(pkg)
+	return path != ""
+}
+
+// BanPackage adds a package, or all packages under an import prefix if prefix
+// is true, to the ban list in db and the loaded one. db is not synced.
+func BanPackage(db *MemDB, path string, prefix bool, reason string) error {
+	path = strings.TrimRight(strings.TrimSpace(path), "/")
+	if path == "" || strings.ContainsAny(path, " \t\r\n") {
+		return fmt.Errorf("invalid path to ban: %q", path)
+	}
+	ent := BanEntry{
+		Prefix: prefix,
+		Reason: reason,
+		Added:  time.Now(),
+	}
+	db.Put(path, ent)
+	registerBan(path, ent)
+	log.Printf("Banned %s(prefix: %v): %s", path, prefix, reason)
+	return nil
+}
+
+// UnbanPackage removes a path from the ban list in db and the loaded one.
+// Returns false if the path was not in the list. db is not synced.
+func UnbanPackage(db *MemDB, path string) bool {
+	path = strings.TrimRight(strings.TrimSpace(path), "/")
+	var ent BanEntry
+	if !db.Get(path, &ent) {
+		return false
+	}
+	db.Delete(path)
+
+	banList.Lock()
+	delete(banList.m, path)
+	banList.Unlock()
+	log.Printf("Unbanned %s", path)
+	return true
+}
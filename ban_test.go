@@ -0,0 +1,45 @@
+package gcse
+
+import (
+	"testing"
+
+	"github.com/daviddengcn/go-assert"
+)
+
+func TestBanPackage(t *testing.T) {
+	defer LoadBanList(NewMemDB("", ""))
+
+	db := NewMemDB("", "")
+	assert.NoErrorf(t, "BanPackage: %v",
+		BanPackage(db, "github.com/bad/pkg", false, "spam"))
+	assert.NoErrorf(t, "BanPackage: %v",
+		BanPackage(db, "github.com/evil/", true, "malware"))
+	assert.IsTrue(t, "BanPackage", BanPackage(db, " ", false, "") != nil)
+
+	LoadBanList(db)
+	assert.IsTrue(t, "exact", IsBanned("github.com/bad/pkg"))
+	assert.IsFalse(t, "sub of exact", IsBanned("github.com/bad/pkg/sub"))
+	assert.IsTrue(t, "prefix", IsBanned("github.com/evil"))
+	assert.IsTrue(t, "sub of prefix", IsBanned("github.com/evil/repo/sub"))
+	assert.IsFalse(t, "not prefix", IsBanned("github.com/evilish/repo"))
+
+	accepted, rejected := NormalizeSubmission(
+		"github.com/evil/repo github.com/good/repo")
+	assert.StringEquals(t, "accepted", accepted, []string{"github.com/good/repo"})
+	assert.Equals(t, "len(rejected)", len(rejected), 1)
+
+	assert.IsTrue(t, "UnbanPackage", UnbanPackage(db, "github.com/evil"))
+	assert.IsFalse(t, "UnbanPackage", UnbanPackage(db, "github.com/evil"))
+	assert.IsFalse(t, "unbanned", IsBanned("github.com/evil/repo"))
+
+	cdb := &CrawlerDB{
+		PackageDB: NewMemDB("", ""),
+		SubmitDB:  NewMemDB("", ""),
+	}
+	cdb.AppendPackage("github.com/bad/pkg", func(string) bool { return false })
+	assert.Equals(t, "PackageDB.Count", cdb.PackageDB.Count(), 0)
+	cdb.SubmitPackage("github.com/bad/pkg")
+	var st SubmitState
+	cdb.SubmitDB.Get("github.com/bad/pkg", &st)
+	assert.Equals(t, "State", st.State, SubmitInvalid)
+}
@@ -20,8 +20,12 @@ crawler fnCrawlerDB   fnCrawlerDB
         fnDocDB       fnDocDB
 		              DBOutSegments
 indexer DBOutSegments IndexSegments
+        fnCrawlerDB(vanity, ban)
 
-server  IndexSegments
+server  IndexSegments     ImportSegments
+        fnCrawlerDB(submit)
+        fnCrawlerDB(ban)  fnCrawlerDB(ban)
+                          QueryLogSegments
 
 */
 package gcse
@@ -30,18 +34,34 @@ import (
 	"github.com/daviddengcn/go-ljson-conf"
 	"github.com/daviddengcn/go-villa"
 	"log"
+	"os"
+	"strings"
 	"time"
+	"unicode"
 )
 
 const (
-	KindIndex = "index"
-	IndexFn   = KindIndex + ".gob"
+	IndexSwapDouble  = "double"
+	IndexSwapRelease = "release"
+
+	KindIndex   = "index"
+	IndexFn     = KindIndex + ".gob"
+	IndexInfoFn = KindIndex + ".json"
+	MMIndexFn   = KindIndex + ".mmidx"
 
 	KindDocDB = "docdb"
 
+	DefaultHostRate = "default"
+
 	FnCrawlerDB = "crawler"
 	KindPackage = "package"
 	KindPerson  = "person"
+	KindBlack   = "black"
+	KindVanity  = "vanity"
+	KindSubmit  = "submit"
+	KindBan     = "ban"
+	KindFailure = "failure"
+	KindLicense = "license"
 	KindToCheck = "tocheck"
 
 	FnToCrawl = "tocrawl"
@@ -50,12 +70,63 @@ const (
 	// key: RawString, value: DocInfo
 	FnDocs    = "docs"
 	FnNewDocs = "newdocs"
+	// written by the server to request a reindexing, removed by the indexer
+	FnReindexRequest = "reindex.json"
+	// GitHub rate limit budgets written by the crawler into CrawlerDBPath
+	FnGithubBudgets = "github-budgets.json"
 )
 
+// RateLimit is the token-bucket setting of a server endpoint.
+type RateLimit struct {
+	// tokens refilled per second, zero means no limit
+	Rate float64
+	// maximum number of tokens
+	Burst int
+}
+
 var (
 	ServerAddr = ":8080"
 	ServerRoot = villa.Path("./server/")
 
+	// How the server switches to a new index. With IndexSwapDouble, the new
+	// index is loaded while the old one is serving, so both are in memory.
+	// With IndexSwapRelease, the server reports not-ready, drains for
+	// ServerDrainDuration and releases the old index before loading the new
+	// one, which bounds the memory at the cost of a short not-ready period.
+	ServerIndexSwap = IndexSwapDouble
+	// time for load balancers to notice the not-ready status
+	ServerDrainDuration = 5 * time.Second
+	// maximum time to wait for in-flight requests when shutting down
+	ServerShutdownTimeout = 30 * time.Second
+
+	// Rate limits per client IP of server endpoints. The key "packages" is for
+	// the packages action of /api, which is limited in addition to "api".
+	ServerRateLimits = map[string]RateLimit{
+		"add":      {Rate: 1. / 60, Burst: 10},
+		"api":      {Rate: 2, Burst: 60},
+		"click":    {Rate: 1, Burst: 30},
+		"packages": {Rate: 1. / 600, Burst: 2},
+	}
+
+	// IPs or CIDRs of the reverse proxies in front of the server. The client
+	// IP is taken from X-Forwarded-For only if the request is from one of
+	// them.
+	ServerTrustedProxies []string
+
+	// Credentials of HTTP basic authentication of /admin, which is disabled
+	// if the password is empty. GCSE_ADMIN_PASSWORD overrides the password.
+	ServerAdminUser     = "admin"
+	ServerAdminPassword = ""
+
+	// A new segment of the query log is started every this duration, zero
+	// to disable the query log.
+	ServerQueryLogRotate = 24 * time.Hour
+
+	// ScorerDefault or ScorerModel, the scorer of search results
+	ServerScorer = ScorerDefault
+	// the RankModel of ScorerModel, trained by tools/ranktrain.go
+	ServerRankModel villa.Path
+
 	DataRoot      = villa.Path("./data/")
 	CrawlerDBPath = DataRoot.Join(FnCrawlerDB)
 	DocsDBPath    = DataRoot.Join(FnDocs)
@@ -64,6 +135,13 @@ var (
 	ImportPath     villa.Path
 	ImportSegments Segments
 
+	// Submissions at /add, producer: server, consumer: server
+	SubmissionPath villa.Path
+
+	// Logs of searches and clicks, producer: server, consumer: querystats
+	QueryLogPath     villa.Path
+	QueryLogSegments Segments
+
 	// producer: crawler, consumer: indexer
 	DBOutPath     villa.Path
 	DBOutSegments Segments
@@ -73,11 +151,45 @@ var (
 	IndexPath     villa.Path
 	IndexSegments Segments
 
+	// Number of MMIndex shards the indexer splits the docs into, by
+	// CalcPackagePartition. The server searches the shards in parallel.
+	IndexShards = 1
+
 	// configures of crawler
 	CrawlByGodocApi   = true
 	CrawlGithubUpdate = true
 	CrawlerDuePerRun  = 1 * time.Hour
 
+	// Maximum requests per second to each host by the crawler. The key
+	// DefaultHostRate is for hosts not listed.
+	CrawlerHostRates = map[string]float64{
+		DefaultHostRate:  0.5,
+		"api.github.com": 1,
+	}
+	// maximum backoff of a host after consecutive errors
+	CrawlerMaxBackoff = 30 * time.Minute
+	// bounds of the adaptive interval between crawls of a package
+	CrawlerMinPackageAge = 24 * time.Hour
+	CrawlerMaxPackageAge = 60 * 24 * time.Hour
+	// time a URL stays in the blacklist after a transient error
+	CrawlerBlackTTL = 24 * time.Hour
+
+	// GitHub OAuth application credentials, overridden by environment
+	// variables GCSE_GITHUB_CLIENT_ID and GCSE_GITHUB_CLIENT_SECRET.
+	GithubClientID     = ""
+	GithubClientSecret = ""
+	// GitHub API tokens used in turn when the rate limit of one is exhausted,
+	// overridden by comma separated GCSE_GITHUB_TOKENS. Tokens take the place
+	// of the application credentials if specified.
+	GithubTokens []string
+	// User-Agent of requests of the crawler
+	CrawlerUserAgent = "Go-Search(http://go-search.org/)"
+
+	// Directories of packages crawled without network access, key: import
+	// prefix, value: a directory of packages or bare git repositories, or a
+	// bare git repository.
+	CrawlerLocalRoots = map[string]villa.Path{}
+
 	/*
 		Increase this to ignore etag of last versions to crawl and parse all
 		packages.
@@ -90,8 +202,11 @@ var (
 			3    Add exported tokens to indexes
 			4    Move TestImports/XTestImports out of Imports, to TestImports
 			4    A bug of checking CrawlerVersion is fixed
+			6    Add methods, consts and vars to exported symbols
+			7    Add examples
+			8    Add license
 	*/
-	CrawlerVersion = 5
+	CrawlerVersion = 8
 )
 
 func init() {
@@ -101,6 +216,30 @@ func init() {
 	}
 	ServerAddr = conf.String("web.addr", ServerAddr)
 	ServerRoot = conf.Path("web.root", ServerRoot)
+	ServerIndexSwap = conf.String("web.index_swap", ServerIndexSwap)
+	ServerDrainDuration = conf.Duration("web.drain", ServerDrainDuration)
+	ServerShutdownTimeout = conf.Duration("web.shutdown_timeout",
+		ServerShutdownTimeout)
+	for name, rl := range ServerRateLimits {
+		rl.Rate = conf.Float("web.ratelimit."+name+".rate", rl.Rate)
+		rl.Burst = conf.Int("web.ratelimit."+name+".burst", rl.Burst)
+		if rl.Rate > 0 && rl.Burst < 1 {
+			// a zero burst rejects every request
+			rl.Burst = 1
+		}
+		ServerRateLimits[name] = rl
+	}
+	ServerTrustedProxies = conf.StringList("web.trusted_proxies",
+		ServerTrustedProxies)
+	ServerQueryLogRotate = conf.Duration("web.querylog_rotate",
+		ServerQueryLogRotate)
+	ServerScorer = conf.String("web.scorer", ServerScorer)
+	ServerAdminUser = conf.String("web.admin.user", ServerAdminUser)
+	ServerAdminPassword = conf.String("web.admin.password",
+		ServerAdminPassword)
+	if password := os.Getenv("GCSE_ADMIN_PASSWORD"); password != "" {
+		ServerAdminPassword = password
+	}
 
 	DataRoot = conf.Path("back.dbroot", DataRoot)
 
@@ -108,6 +247,16 @@ func init() {
 	ImportPath.MkdirAll(0755)
 	ImportSegments = segments(ImportPath)
 
+	SubmissionPath = DataRoot.Join("submissions")
+	SubmissionPath.MkdirAll(0755)
+
+	QueryLogPath = DataRoot.Join("querylog")
+	QueryLogPath.MkdirAll(0755)
+	QueryLogSegments = segments(QueryLogPath)
+
+	ServerRankModel = conf.Path("web.rank_model",
+		DataRoot.Join("rankmodel.json"))
+
 	DBOutPath = DataRoot.Join("dbout")
 	DBOutPath.MkdirAll(0755)
 	DBOutSegments = segments(DBOutPath)
@@ -116,7 +265,47 @@ func init() {
 	IndexPath.MkdirAll(0755)
 	IndexSegments = segments(IndexPath)
 
+	IndexShards = conf.Int("indexer.shards", IndexShards)
+
 	CrawlByGodocApi = conf.Bool("crawler.godoc", CrawlByGodocApi)
 	CrawlGithubUpdate = conf.Bool("crawler.github_update", CrawlGithubUpdate)
 	CrawlerDuePerRun = conf.Duration("crawler.due_per_run", CrawlerDuePerRun)
+	for host, rate := range conf.Object("crawler.host_rates", nil) {
+		if r, ok := rate.(float64); ok {
+			CrawlerHostRates[host] = r
+		} else {
+			log.Printf("Invalid rate of %s in crawler.host_rates: %v", host,
+				rate)
+		}
+	}
+	CrawlerMaxBackoff = conf.Duration("crawler.max_backoff", CrawlerMaxBackoff)
+	CrawlerMinPackageAge = conf.Duration("crawler.min_package_age",
+		CrawlerMinPackageAge)
+	CrawlerMaxPackageAge = conf.Duration("crawler.max_package_age",
+		CrawlerMaxPackageAge)
+	CrawlerBlackTTL = conf.Duration("crawler.black_ttl", CrawlerBlackTTL)
+	CrawlerUserAgent = conf.String("crawler.user_agent", CrawlerUserAgent)
+
+	for prefix, root := range conf.Object("crawler.local", nil) {
+		if r, ok := root.(string); ok {
+			CrawlerLocalRoots[prefix] = villa.Path(r)
+		} else {
+			log.Printf("Invalid directory of %s in crawler.local: %v", prefix,
+				root)
+		}
+	}
+
+	GithubClientID = conf.String("crawler.github.client_id", GithubClientID)
+	GithubClientSecret = conf.String("crawler.github.client_secret",
+		GithubClientSecret)
+	GithubTokens = conf.StringList("crawler.github.tokens", GithubTokens)
+	if id := os.Getenv("GCSE_GITHUB_CLIENT_ID"); id != "" {
+		GithubClientID = id
+		GithubClientSecret = os.Getenv("GCSE_GITHUB_CLIENT_SECRET")
+	}
+	if tokens := os.Getenv("GCSE_GITHUB_TOKENS"); tokens != "" {
+		GithubTokens = strings.FieldsFunc(tokens, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+	}
 }
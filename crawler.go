@@ -6,15 +6,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go/ast"
 	godoc "go/doc"
+	"go/parser"
+	"go/token"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -53,43 +57,141 @@ func ReadPackages(segm Segment) (pkgs []string, err error) {
 	return pkgs, err
 }
 
+// BlackEntry is an entry of the blacklist of BlackRequest.
+type BlackEntry struct {
+	// zero for a timeout
+	StatusCode int
+	// the status line of the response or the error message
+	Status  string
+	Added   time.Time
+	Expires time.Time
+}
+
+// isBlackStatus returns whether a response status is transient and should be
+// blacklisted for a while.
+func isBlackStatus(code int) bool {
+	switch code {
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// BlackRequest is a doc.HttpClient which remembers the GET URLs failed with a
+// transient status (500, 502, 503 or 504) or a timeout for CrawlerBlackTTL.
+// Requests to those URLs fail immediately without being sent before the entry
+// expires. The blacklist, keyed by URL with BlackEntry values, is kept in db,
+// which can be a persistent MemDB.
 type BlackRequest struct {
-	sync.RWMutex
-	badUrls map[string]http.Response
-	client  doc.HttpClient
+	db     *MemDB
+	client doc.HttpClient
+}
+
+func NewBlackRequest(client doc.HttpClient, db *MemDB) *BlackRequest {
+	return &BlackRequest{
+		db:     db,
+		client: client,
+	}
+}
+
+// credential parameters of the query which are removed from the URLs used as
+// keys of the blacklist or logged
+var credentialParams = []string{"client_id", "client_secret", "access_token"}
+
+// redactURL returns the URL without the credential parameters of the query.
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	q := u.Query()
+	for _, p := range credentialParams {
+		q.Del(p)
+	}
+	redacted := *u
+	redacted.RawQuery = q.Encode()
+	return redacted.String()
+}
+
+// redactError returns the message of an error returned by client.Do, without
+// the URL which may contain credentials.
+func redactError(err error) string {
+	if ue, ok := err.(*url.Error); ok {
+		return ue.Err.Error()
+	}
+	return err.Error()
 }
 
 func (br *BlackRequest) Do(req *http.Request) (*http.Response, error) {
 	if req.Method != "GET" {
 		return br.client.Do(req)
 	}
-	u := req.URL.String()
+	u := redactURL(req.URL)
 	log.Printf("BlackRequest.Do(GET(%v))", u)
-	br.RLock()
-	r, ok := br.badUrls[u]
-	br.RUnlock()
-	if ok {
-		log.Printf("%s was found in 500 blacklist, return it directly", u)
-		r.Body = villa.NewPByteSlice(nil)
-		return &r, nil
+	var ent BlackEntry
+	if br.db.Get(u, &ent) {
+		if time.Now().Before(ent.Expires) {
+			log.Printf("%s was found in blacklist(%s), return it directly", u,
+				ent.Status)
+			if ent.StatusCode == 0 {
+				return nil, fmt.Errorf("%s blacklisted until %v: %s", u,
+					ent.Expires, ent.Status)
+			}
+			return &http.Response{
+				Status:     ent.Status,
+				StatusCode: ent.StatusCode,
+				Header:     make(http.Header),
+				Body:       villa.NewPByteSlice(nil),
+				Request:    req,
+			}, nil
+		}
+		br.db.Delete(u)
 	}
+
 	resp, err := br.client.Do(req)
+	now := time.Now()
 	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			log.Printf("Put %s into blacklist: %s", u, redactError(err))
+			br.db.Put(u, BlackEntry{
+				Status:  redactError(err),
+				Added:   now,
+				Expires: now.Add(CrawlerBlackTTL),
+			})
+		}
 		return resp, err
 	}
 
-	if resp.StatusCode == 500 {
-		log.Printf("Put %s into 500 blacklist", u)
-		r := *resp
-		r.Body = nil
-		br.Lock()
-		br.badUrls[u] = r
-		br.Unlock()
+	if isBlackStatus(resp.StatusCode) {
+		log.Printf("Put %s into blacklist: %s", u, resp.Status)
+		br.db.Put(u, BlackEntry{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Added:      now,
+			Expires:    now.Add(CrawlerBlackTTL),
+		})
 	}
 	return resp, nil
 }
 
-func GenHttpClient(proxy string) doc.HttpClient {
+// PurgeBlacklist removes expired entries of a blacklist. Returns the number
+// of entries removed.
+func PurgeBlacklist(db *MemDB) int {
+	var expired []string
+	now := time.Now()
+	db.Iterate(func(u string, val interface{}) error {
+		if ent, ok := val.(BlackEntry); !ok || !now.Before(ent.Expires) {
+			expired = append(expired, u)
+		}
+		return nil
+	})
+	for _, u := range expired {
+		db.Delete(u)
+	}
+	return len(expired)
+}
+
+func newTransport(proxy string) *http.Transport {
 	tp := &http.Transport{
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: true,
@@ -101,17 +203,31 @@ func GenHttpClient(proxy string) doc.HttpClient {
 			tp.Proxy = http.ProxyURL(proxyURL)
 		}
 	}
+	return tp
+}
 
-	return &BlackRequest{
-		badUrls: make(map[string]http.Response),
-		client: &http.Client{
-			Transport: tp,
-		},
-	}
+func GenHttpClient(proxy string) doc.HttpClient {
+	return NewBlackRequest(&http.Client{
+		Transport: newTransport(proxy),
+	}, NewMemDB("", ""))
+}
+
+// GenCrawlerHttpClient returns an http client whose requests, except the
+// ones blacklisted in blackDB, are scheduled by the returned HostScheduler
+// and authorized by the returned GithubAuth.
+func GenCrawlerHttpClient(proxy string, deadline time.Time,
+	blackDB *MemDB) (doc.HttpClient, *HostScheduler, *GithubAuth) {
+	auth := NewGithubAuth(&http.Client{
+		Transport: newTransport(proxy),
+	})
+	sched := NewHostScheduler(auth, deadline)
+	return NewBlackRequest(sched, blackDB), sched, auth
 }
 
+// AuthorOfPackage returns the author of a package. Packages of custom import
+// paths in registered VanityRepos are of the author of the repository.
 func AuthorOfPackage(pkg string) string {
-	parts := strings.Split(pkg, "/")
+	parts := strings.Split(repoPathOfPackage(pkg), "/")
 	if len(parts) == 0 {
 		return ""
 	}
@@ -121,8 +237,6 @@ func AuthorOfPackage(pkg string) string {
 		if len(parts) > 1 {
 			return parts[1]
 		}
-	case "llamaslayers.net":
-		return "Nightgunner5"
 	case "launchpad.net":
 		if len(parts) > 1 && strings.HasPrefix(parts[1], "~") {
 			return parts[1][1:]
@@ -141,23 +255,17 @@ func HostOfPackage(pkg string) string {
 
 // core project of a packaage
 func ProjectOfPackage(pkg string) string {
+	pkg = repoPathOfPackage(pkg)
 	parts := strings.Split(pkg, "/")
 	if len(parts) == 0 {
 		return ""
 	}
 
 	switch parts[0] {
-	case "llamaslayers.net", "bazil.org":
-		if len(parts) > 1 {
-			return parts[1]
-		}
 	case "github.com", "code.google.com", "bitbucket.org", "labix.org":
 		if len(parts) > 2 {
 			return parts[2]
 		}
-	case "golanger.com":
-		return "golangers"
-
 	case "launchpad.net":
 		if len(parts) > 2 && strings.HasPrefix(parts[1], "~") {
 			return parts[2]
@@ -165,30 +273,28 @@ func ProjectOfPackage(pkg string) string {
 		if len(parts) > 1 {
 			return parts[1]
 		}
-	case "cgl.tideland.biz":
-		return "tcgl"
 	}
 	return pkg
 }
 
+// FullProjectOfPackage returns the import path of the project of a package.
+// For a package in a registered VanityRepo, it is the import prefix of the
+// repository.
 func FullProjectOfPackage(pkg string) string {
+	if prefix, _ := vanityRepoOf(pkg); prefix != "" {
+		return prefix
+	}
+
 	parts := strings.Split(pkg, "/")
 	if len(parts) == 0 {
 		return ""
 	}
 
 	switch parts[0] {
-	case "llamaslayers.net", "bazil.org":
-		if len(parts) > 2 {
-			parts = parts[:2]
-		}
 	case "github.com", "code.google.com", "bitbucket.org", "labix.org":
 		if len(parts) > 3 {
 			parts = parts[:3]
 		}
-	case "golanger.com":
-		return "golanger.com/golangers"
-
 	case "launchpad.net":
 		if len(parts) > 2 && strings.HasPrefix(parts[1], "~") {
 			parts = parts[:2]
@@ -196,8 +302,6 @@ func FullProjectOfPackage(pkg string) string {
 		if len(parts) > 1 {
 			parts = parts[:2]
 		}
-	case "cgl.tideland.biz":
-		return "cgl.tideland.biz/tcgl"
 	default:
 		if len(parts) > 3 {
 			parts = parts[:3]
@@ -218,7 +322,9 @@ type Package struct {
 	ReadmeData  string
 	Imports     []string
 	TestImports []string
-	Exported    []string // exported tokens(funcs/types)
+	Exported    []string // exported symbols, see exportedSymbols
+	Examples    []Example
+	License     string // SPDX identifier, see DetectLicense
 
 	References []string
 	Etag       string
@@ -318,6 +424,186 @@ func fuseStars(a, b int) int {
 	return (a + b) * 3 / 4
 }
 
+// valueNames returns the exported names declared in a const/var declaration.
+func valueNames(decl string) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+decl, 0)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, d := range f.Decls {
+		gd, ok := d.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs, ok := spec.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+			for _, name := range vs.Names {
+				if name.IsExported() {
+					names = append(names, name.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// exportedSymbols returns the exported funcs, types, consts, vars and
+// methods of a package. Methods are in the form of Type.Method.
+func exportedSymbols(pdoc *doc.Package) []string {
+	var exported villa.StrSet
+	putValues := func(values []*doc.Value) {
+		for _, v := range values {
+			exported.Put(valueNames(v.Decl.Text)...)
+		}
+	}
+	putValues(pdoc.Consts)
+	putValues(pdoc.Vars)
+	for _, f := range pdoc.Funcs {
+		exported.Put(f.Name)
+	}
+	for _, t := range pdoc.Types {
+		exported.Put(t.Name)
+		putValues(t.Consts)
+		putValues(t.Vars)
+		for _, f := range t.Funcs {
+			exported.Put(f.Name)
+		}
+		for _, m := range t.Methods {
+			exported.Put(t.Name + "." + m.Name)
+		}
+	}
+	return exported.Elements()
+}
+
+const (
+	maxExamples     = 20
+	maxExampleBytes = 4 * 1024
+)
+
+// packageExamples returns the examples of a package and its funcs, types and
+// methods, at most maxExamples of them.
+func packageExamples(pdoc *doc.Package) []Example {
+	var examples []Example
+	add := func(exs []*doc.Example) {
+		for _, e := range exs {
+			if len(examples) >= maxExamples {
+				return
+			}
+			code := e.Code.Text
+			if len(code) > maxExampleBytes {
+				// skip instead of cutting, a truncated example is useless
+				continue
+			}
+			examples = append(examples, Example{
+				Name:   e.Name,
+				Doc:    e.Doc,
+				Code:   code,
+				Output: e.Output,
+			})
+		}
+	}
+	add(pdoc.Examples)
+	for _, f := range pdoc.Funcs {
+		add(f.Examples)
+	}
+	for _, t := range pdoc.Types {
+		add(t.Examples)
+		for _, f := range t.Funcs {
+			add(f.Examples)
+		}
+		for _, m := range t.Methods {
+			add(m.Examples)
+		}
+	}
+	return examples
+}
+
+const maxLicenseBytes = 64 * 1024
+
+func httpGet(httpClient doc.HttpClient, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return httpClient.Do(req)
+}
+
+// githubRepoOfPackage returns the repository of a package on GitHub, e.g.
+// github.com/owner/repo, "" if not on GitHub.
+func githubRepoOfPackage(pkg string) string {
+	parts := strings.Split(pkg, "/")
+	if len(parts) < 3 || parts[0] != "github.com" {
+		return ""
+	}
+	return strings.Join(parts[:3], "/")
+}
+
+// FetchLicense finds a license file, e.g. LICENSE or COPYING, in the root of
+// the GitHub project of a package and returns its SPDX identifier detected by
+// DetectLicense, and the ETag of the listing of the root. Returns "" if the
+// package is not on GitHub or no license file is found. If etag is not empty
+// and the root is not modified, ErrPackageNotModifed is returned; such a
+// conditional request does not count against the rate limit of GitHub.
+func FetchLicense(httpClient doc.HttpClient, pkg string,
+	etag string) (license, newEtag string, err error) {
+	repo := githubRepoOfPackage(pkg)
+	if repo == "" {
+		return "", "", nil
+	}
+	req, err := http.NewRequest("GET", "https://api.github.com/repos/"+
+		strings.TrimPrefix(repo, "github.com/")+"/contents/", nil)
+	if err != nil {
+		return "", "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return "", etag, ErrPackageNotModifed
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("list files of %s: status %s", pkg,
+			resp.Status)
+	}
+	newEtag = resp.Header.Get("ETag")
+	var files []struct {
+		Name        string `json:"name"`
+		Type        string `json:"type"`
+		DownloadURL string `json:"download_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		return "", "", villa.NestErrorf(err, "list files of %s", pkg)
+	}
+
+	for _, f := range files {
+		if f.Type != "file" || !IsLicenseFile(f.Name) {
+			continue
+		}
+		resp, err := httpGet(httpClient, f.DownloadURL)
+		if err != nil {
+			return "", "", err
+		}
+		text, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxLicenseBytes))
+		resp.Body.Close()
+		if err != nil {
+			return "", "", err
+		}
+		if license := DetectLicense(string(text)); license != "" {
+			return license, newEtag, nil
+		}
+	}
+	return "", newEtag, nil
+}
+
 func CrawlPackage(httpClient doc.HttpClient, pkg string,
 	etag string) (p *Package, err error) {
 	defer func() {
@@ -328,6 +614,10 @@ func CrawlPackage(httpClient doc.HttpClient, pkg string,
 		}
 	}()
 
+	if IsLocalPackage(pkg) {
+		return CrawlLocalPackage(pkg, etag)
+	}
+
 	pdoc, err := doc.Get(httpClient, pkg, etag)
 	if err == doc.ErrNotModified {
 		return nil, ErrPackageNotModifed
@@ -365,6 +655,10 @@ func CrawlPackage(httpClient doc.HttpClient, pkg string,
 		pdoc.Synopsis = godoc.Synopsis(ReadmeToText(readmeFn, readmeData))
 	}
 
+	// license files are fetched from GitHub by CrawlerDB.PackageLicense,
+	// other hosts are checked by the README
+	license := ReadmeLicense(readmeData)
+
 	if len(readmeData) > 100*1024 {
 		readmeData = readmeData[:100*1024]
 	}
@@ -374,13 +668,7 @@ func CrawlPackage(httpClient doc.HttpClient, pkg string,
 	testImports.Put(pdoc.XTestImports...)
 	testImports.Delete(imports...)
 
-	var exported villa.StrSet
-	for _, f := range pdoc.Funcs {
-		exported.Put(f.Name)
-	}
-	for _, t := range pdoc.Types {
-		exported.Put(t.Name)
-	}
+	exported := exportedSymbols(pdoc)
 
 	return &Package{
 		Package:    pdoc.ImportPath,
@@ -395,7 +683,9 @@ func CrawlPackage(httpClient doc.HttpClient, pkg string,
 
 		Imports:     imports,
 		TestImports: testImports.Elements(),
-		Exported:    exported.Elements(),
+		Exported:    exported,
+		Examples:    packageExamples(pdoc),
+		License:     license,
 
 		References: pdoc.References,
 		Etag:       pdoc.Etag,
@@ -554,6 +844,39 @@ type CrawlingEntry struct {
 	// if gcse.CrawlerVersion is different from this value, etag is ignored
 	Version int
 	Etag    string
+
+	// Interval between crawls of a package adapted to how often it changes,
+	// see CrawlerDB.PackageCrawled. Zero for DefaultPackageAge.
+	Interval time.Duration
+	// Number of successful crawls and the ones finding changes, halved
+	// every maxCrawlStats crawls.
+	Crawls  int
+	Changes int
+	// number of packages importing the package when last crawled
+	Importers int
+	// true if submitted by a user and not crawled yet
+	Submitted bool
+}
+
+// number of importers for a package to be crawled before unpopular ones
+const popularImporters = 10
+
+// CrawlBefore returns whether due entry a should be crawled before b: user
+// submissions first, then popular packages by the number of importers, then
+// others by how long they are overdue.
+func CrawlBefore(a, b *CrawlingEntry) bool {
+	if a.Submitted != b.Submitted {
+		return a.Submitted
+	}
+	aPopular, bPopular := a.Importers >= popularImporters,
+		b.Importers >= popularImporters
+	if aPopular != bPopular {
+		return aPopular
+	}
+	if aPopular && a.Importers != b.Importers {
+		return a.Importers > b.Importers
+	}
+	return a.ScheduleTime.Before(b.ScheduleTime)
 }
 
 func (c *CrawlingEntry) WriteTo(w sophie.Writer) error {
@@ -566,6 +889,25 @@ func (c *CrawlingEntry) WriteTo(w sophie.Writer) error {
 	if err := sophie.String(c.Etag).WriteTo(w); err != nil {
 		return err
 	}
+	if err := sophie.VInt(c.Interval / time.Second).WriteTo(w); err != nil {
+		return err
+	}
+	if err := sophie.VInt(c.Crawls).WriteTo(w); err != nil {
+		return err
+	}
+	if err := sophie.VInt(c.Changes).WriteTo(w); err != nil {
+		return err
+	}
+	if err := sophie.VInt(c.Importers).WriteTo(w); err != nil {
+		return err
+	}
+	submitted := sophie.VInt(0)
+	if c.Submitted {
+		submitted = 1
+	}
+	if err := submitted.WriteTo(w); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -579,6 +921,25 @@ func (c *CrawlingEntry) ReadFrom(r sophie.Reader, l int) error {
 	if err := (*sophie.String)(&c.Etag).ReadFrom(r, -1); err != nil {
 		return err
 	}
+	var secs sophie.VInt
+	if err := secs.ReadFrom(r, -1); err != nil {
+		return err
+	}
+	c.Interval = time.Duration(secs) * time.Second
+	if err := (*sophie.VInt)(&c.Crawls).ReadFrom(r, -1); err != nil {
+		return err
+	}
+	if err := (*sophie.VInt)(&c.Changes).ReadFrom(r, -1); err != nil {
+		return err
+	}
+	if err := (*sophie.VInt)(&c.Importers).ReadFrom(r, -1); err != nil {
+		return err
+	}
+	var submitted sophie.VInt
+	if err := submitted.ReadFrom(r, -1); err != nil {
+		return err
+	}
+	c.Submitted = submitted != 0
 	return nil
 }
 
@@ -657,4 +1018,5 @@ func FetchAllPackagesInGodoc(httpClient doc.HttpClient) ([]string, error) {
 
 func init() {
 	gob.RegisterName("main.CrawlingEntry", CrawlingEntry{})
+	gob.Register(BlackEntry{})
 }
@@ -17,12 +17,18 @@ import (
 var (
 	AppStopTime time.Time
 	cDB         *gcse.CrawlerDB
+	// schedules the requests of crawling to each host
+	crawlSched *gcse.HostScheduler
+	// authorizes the requests to the GitHub API
+	githubAuth *gcse.GithubAuth
 )
 
 func init() {
-	doc.SetGithubCredentials("94446b37edb575accd8b",
-		"15f55815f0515a3f6ad057aaffa9ea83dceb220b")
-	doc.SetUserAgent("Go-Search(http://go-search.org/)")
+	if len(gcse.GithubTokens) == 0 && gcse.GithubClientID != "" {
+		doc.SetGithubCredentials(gcse.GithubClientID,
+			gcse.GithubClientSecret)
+	}
+	doc.SetUserAgent(gcse.CrawlerUserAgent)
 }
 
 func syncDatabases() {
@@ -56,7 +62,9 @@ func loadAllDocsPkgs(in kv.DirInput) error {
 				return err
 			}
 			allDocsPkgs.Put(string(key))
-			// value is ignored
+			for _, imp := range val.Imports {
+				importerCounts[imp]++
+			}
 		}
 	}
 	return nil
@@ -85,6 +93,8 @@ func main() {
 
 	// Load CrawlerDB
 	cDB = gcse.LoadCrawlerDB()
+	gcse.LoadVanityRepos(cDB.VanityDB)
+	gcse.LoadBanList(gcse.NewMemDB(gcse.CrawlerDBPath, gcse.KindBan))
 
 	fpDataRoot := sophie.FsPath{
 		Fs:   sophie.LocalFS,
@@ -103,7 +113,29 @@ func main() {
 	fpCrawler := fpDataRoot.Join(gcse.FnCrawlerDB)
 	fpToCrawl := fpDataRoot.Join(gcse.FnToCrawl)
 
-	httpClient := gcse.GenHttpClient("")
+	if n := gcse.PurgeBlacklist(cDB.BlackDB); n > 0 {
+		log.Printf("%d expired entries removed from the blacklist", n)
+	}
+	if n := gcse.PurgeSubmitStates(cDB.SubmitDB); n > 0 {
+		log.Printf("%d expired states of submitted packages removed", n)
+	}
+	if n := gcse.PurgeCrawlFailures(cDB.FailureDB); n > 0 {
+		log.Printf("%d expired crawling failures removed", n)
+	}
+	httpClient, sched, auth := gcse.GenCrawlerHttpClient("", AppStopTime,
+		cDB.BlackDB)
+	crawlSched, githubAuth = sched, auth
+	if len(gcse.GithubTokens) == 0 && gcse.GithubClientID == "" {
+		log.Printf("No GitHub credentials configured, the rate limit is low")
+	} else {
+		if err := githubAuth.Validate(); err != nil {
+			log.Fatalf("Invalid GitHub credentials: %v", err)
+		}
+		githubAuth.LogBudgets()
+		if err := githubAuth.SaveBudgets(); err != nil {
+			log.Printf("SaveBudgets failed: %v", err)
+		}
+	}
 
 	fpNewDocs := fpCrawler.Join(gcse.FnNewDocs)
 	fpNewDocs.Remove()
@@ -121,10 +153,14 @@ func main() {
 			errPkg, errPsn)
 	}
 
-	if err := processImports(); err != nil {
-		log.Printf("processImports failed: %v", err)
+	if err := cDB.ProcessImports(); err != nil {
+		log.Printf("ProcessImports failed: %v", err)
 	}
 
 	syncDatabases()
+	githubAuth.LogBudgets()
+	if err := githubAuth.SaveBudgets(); err != nil {
+		log.Printf("SaveBudgets failed: %v", err)
+	}
 	log.Println("crawler stopped...")
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"log"
-	"math/rand"
 	"strings"
 	"time"
 
@@ -14,20 +13,16 @@ import (
 	"github.com/daviddengcn/sophie/kv"
 )
 
-const (
-	DefaultPackageAge = 10 * 24 * time.Hour
-)
-
 var (
 	allDocsPkgs villa.StrSet
+	// number of packages in docs importing a package
+	importerCounts = make(map[string]int)
 )
 
-// Schedule a package for next crawling cycle, commonly after a successful
-// update.
-func schedulePackageNextCrawl(pkg string, etag string) {
-	cDB.SchedulePackage(pkg, time.Now().Add(time.Duration(
-		float64(DefaultPackageAge)*(1+(rand.Float64()-0.5)*0.2))), etag)
-
+// Schedule a package for next crawling cycle after a successful crawl.
+// changed is false if the package was not modified.
+func schedulePackageNextCrawl(pkg string, etag string, changed bool) {
+	cDB.PackageCrawled(pkg, changed, etag, importerCounts[pkg])
 }
 
 func appendPackage(pkg string) {
@@ -48,6 +43,8 @@ func packageToDoc(p *gcse.Package) gcse.DocInfo {
 		ReadmeFn:    p.ReadmeFn,
 		ReadmeData:  p.ReadmeData,
 		Exported:    p.Exported,
+		Examples:    p.Examples,
+		License:     p.License,
 	}
 
 	d.Imports = nil
@@ -81,7 +78,7 @@ func packageToDoc(p *gcse.Package) gcse.DocInfo {
 		appendPackage(ref)
 	}
 
-	schedulePackageNextCrawl(d.Package, p.Etag)
+	schedulePackageNextCrawl(d.Package, p.Etag, true)
 
 	return d
 }
@@ -90,7 +87,6 @@ type PackageCrawler struct {
 	crawlerMapper
 
 	part       int
-	failCount  int
 	httpClient doc.HttpClient
 }
 
@@ -109,12 +105,31 @@ func (pc *PackageCrawler) Map(key, val sophie.SophieWriter,
 		// if gcse.CrawlerVersion is larger than Version, Etag is ignored.
 		ent.Etag = ""
 	}
+	if gcse.IsBanned(pkg) {
+		nda := gcse.NewDocAction{
+			Action: gcse.NDA_DEL,
+		}
+		c[0].Collect(sophie.RawString(pkg), &nda)
+		cDB.PackageDB.Delete(pkg)
+		log.Printf("Remove banned package %s", pkg)
+		return nil
+	}
 	log.Printf("Crawling package %v\n", pkg)
 
 	p, err := gcse.CrawlPackage(pc.httpClient, pkg, ent.Etag)
 	_ = p
+	if gcse.IsCrawlDeadline(err) {
+		// the host is busy until the deadline, left to the next run
+		log.Printf("Crawling pkg %s skipped: %v", pkg, err)
+		if crawlSched.Expired() {
+			log.Printf("Timeout(key = %v), part %d returns EOM", key, pc.part)
+			return mr.EOM
+		}
+		return nil
+	}
 	if err != nil && err != gcse.ErrPackageNotModifed {
 		log.Printf("Crawling pkg %s failed: %v", pkg, err)
+		cDB.CrawlFailed(pkg, err)
 		if gcse.IsBadPackage(err) {
 			// a wrong path
 			nda := gcse.NewDocAction{
@@ -123,37 +138,36 @@ func (pc *PackageCrawler) Map(key, val sophie.SophieWriter,
 			c[0].Collect(sophie.RawString(pkg), &nda)
 			cDB.PackageDB.Delete(pkg)
 			log.Printf("Remove wrong package %s", pkg)
+			cDB.SubmitCrawlResult(pkg, ent.Submitted, gcse.SubmitInvalid,
+				err.Error())
 		} else {
-			pc.failCount++
-
-			cDB.SchedulePackage(pkg, time.Now().Add(12*time.Hour), ent.Etag)
-
-			if pc.failCount >= 10 || strings.Contains(err.Error(), "403") {
-				durToSleep := 10 * time.Minute
-				if time.Now().Add(durToSleep).After(AppStopTime) {
-					log.Printf("Timeout(key = %v), part %d returns EOM",
-						key, pc.part)
-					return mr.EOM
-				}
-
-				log.Printf("Last ten crawling packages failed, sleep for a while...(current: %s)",
-					pkg)
-				time.Sleep(durToSleep)
-				pc.failCount = 0
+			cDB.RetryPackage(pkg, time.Now().Add(12*time.Hour), ent.Etag)
+			cDB.SubmitCrawlResult(pkg, ent.Submitted, gcse.SubmitFailed,
+				err.Error())
+
+			if crawlSched.Expired() {
+				log.Printf("Timeout(key = %v), part %d returns EOM", key,
+					pc.part)
+				return mr.EOM
 			}
 		}
 		return nil
 	}
 
-	pc.failCount = 0
+	cDB.ResolveVanity(pc.httpClient, pkg)
+	cDB.SubmitCrawlResult(pkg, ent.Submitted, gcse.SubmitCrawled, "")
+
 	if err == gcse.ErrPackageNotModifed {
 		// TODO crawling stars for unchanged project
 		log.Printf("Package %s unchanged!", pkg)
-		schedulePackageNextCrawl(pkg, ent.Etag)
+		schedulePackageNextCrawl(pkg, ent.Etag, false)
 		return nil
 	}
 
 	log.Printf("Crawled package %s success!", pkg)
+	if p.License == "" {
+		p.License = cDB.PackageLicense(pc.httpClient, pkg)
+	}
 
 	nda := gcse.NewDocAction{
 		Action:  gcse.NDA_UPDATE,
@@ -162,8 +176,6 @@ func (pc *PackageCrawler) Map(key, val sophie.SophieWriter,
 	c[0].Collect(sophie.RawString(pkg), &nda)
 	log.Printf("Package %s saved!", pkg)
 
-	time.Sleep(10 * time.Second)
-
 	return nil
 }
 
@@ -3,7 +3,6 @@ package main
 import (
 	"log"
 	"math/rand"
-	"strings"
 	"time"
 
 	"github.com/daviddengcn/gcse"
@@ -21,7 +20,6 @@ type PersonCrawler struct {
 	crawlerMapper
 
 	part       int
-	failCount  int
 	httpClient doc.HttpClient
 }
 
@@ -47,23 +45,23 @@ func (pc *PersonCrawler) Map(key, val sophie.SophieWriter,
 	log.Printf("Crawling person %v\n", id)
 
 	p, err := gcse.CrawlPerson(pc.httpClient, id)
+	if gcse.IsCrawlDeadline(err) {
+		// the host is busy until the deadline, left to the next run
+		log.Printf("Crawling person %s skipped: %v", id, err)
+		if crawlSched.Expired() {
+			log.Printf("Timeout(key = %v), PersonCrawler part %d returns EOM", key, pc.part)
+			return mr.EOM
+		}
+		return nil
+	}
 	if err != nil {
-		pc.failCount++
 		log.Printf("Crawling person %s failed: %v", id, err)
 
 		cDB.SchedulePerson(id, time.Now().Add(12*time.Hour))
 
-		if pc.failCount >= 10 || strings.Contains(err.Error(), "403") {
-			durToSleep := 10 * time.Minute
-			if time.Now().Add(durToSleep).After(AppStopTime) {
-				log.Printf("Timeout(key = %v), PersonCrawler part %d returns EOM", key, pc.part)
-				return mr.EOM
-			}
-
-			log.Printf("Last ten crawling persons failed, sleep for a while...(current: %s)",
-				id)
-			time.Sleep(durToSleep)
-			pc.failCount = 0
+		if crawlSched.Expired() {
+			log.Printf("Timeout(key = %v), PersonCrawler part %d returns EOM", key, pc.part)
+			return mr.EOM
 		}
 		return nil
 	}
@@ -71,9 +69,6 @@ func (pc *PersonCrawler) Map(key, val sophie.SophieWriter,
 	log.Printf("Crawled person %s success!", id)
 	pushPerson(p)
 	log.Printf("Push person %s success", id)
-	pc.failCount = 0
-
-	time.Sleep(10 * time.Second)
 
 	return nil
 }
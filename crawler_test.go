@@ -3,6 +3,7 @@ package gcse
 import (
 	"errors"
 	"net/http"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -136,6 +137,11 @@ func TestCrawlingEntry(t *testing.T) {
 		ScheduleTime: time.Now(),
 		Version:      19,
 		Etag:         "Hello",
+		Interval:     36 * time.Hour,
+		Crawls:       3,
+		Changes:      1,
+		Importers:    12,
+		Submitted:    true,
 	}
 
 	var buf villa.ByteSlice
@@ -147,6 +153,21 @@ func TestCrawlingEntry(t *testing.T) {
 	assert.StringEquals(t, "dst", dst, src)
 }
 
+func TestCrawlBefore(t *testing.T) {
+	now := time.Now()
+	submitted := &CrawlingEntry{ScheduleTime: now, Submitted: true}
+	popular := &CrawlingEntry{ScheduleTime: now, Importers: 100}
+	lessPopular := &CrawlingEntry{ScheduleTime: now, Importers: 10}
+	stale := &CrawlingEntry{ScheduleTime: now.Add(-time.Hour), Importers: 1}
+	due := &CrawlingEntry{ScheduleTime: now}
+
+	ordered := []*CrawlingEntry{submitted, popular, lessPopular, stale, due}
+	for i := 1; i < len(ordered); i++ {
+		assert.IsTrue(t, "before", CrawlBefore(ordered[i-1], ordered[i]))
+		assert.IsFalse(t, "after", CrawlBefore(ordered[i], ordered[i-1]))
+	}
+}
+
 func TestFullProjectOfPackage(t *testing.T) {
 	DATA := []string{
 		"github.com/daviddengcn/gcse", "github.com/daviddengcn/gcse",
@@ -160,3 +181,61 @@ func TestFullProjectOfPackage(t *testing.T) {
 			FullProjectOfPackage(pkg), prj)
 	}
 }
+
+func TestBlackRequest(t *testing.T) {
+	calls := 0
+	status := http.StatusBadGateway
+	db := NewMemDB("", "")
+	br := NewBlackRequest(httpClientFunc(func(req *http.Request) (
+		*http.Response, error) {
+		calls++
+		return &http.Response{
+			Status:     http.StatusText(status),
+			StatusCode: status,
+			Body:       villa.NewPByteSlice(nil),
+		}, nil
+	}), db)
+
+	req, _ := http.NewRequest("GET", "http://example.com/a", nil)
+	resp, err := br.Do(req)
+	assert.NoErrorf(t, "br.Do: %v", err)
+	assert.Equals(t, "StatusCode", resp.StatusCode, http.StatusBadGateway)
+	assert.Equals(t, "db.Count", db.Count(), 1)
+
+	// blacklisted, not sent
+	status = http.StatusOK
+	resp, err = br.Do(req)
+	assert.NoErrorf(t, "br.Do: %v", err)
+	assert.Equals(t, "StatusCode", resp.StatusCode, http.StatusBadGateway)
+	assert.Equals(t, "calls", calls, 1)
+
+	// expired
+	var ent BlackEntry
+	db.Get(req.URL.String(), &ent)
+	ent.Expires = time.Now().Add(-time.Second)
+	db.Put(req.URL.String(), ent)
+	resp, err = br.Do(req)
+	assert.NoErrorf(t, "br.Do: %v", err)
+	assert.Equals(t, "StatusCode", resp.StatusCode, http.StatusOK)
+	assert.Equals(t, "calls", calls, 2)
+	assert.Equals(t, "db.Count", db.Count(), 0)
+
+	db.Put("http://example.com/b", BlackEntry{
+		Expires: time.Now().Add(-time.Second),
+	})
+	db.Put("http://example.com/c", BlackEntry{
+		Expires: time.Now().Add(time.Hour),
+	})
+	assert.Equals(t, "PurgeBlacklist", PurgeBlacklist(db), 1)
+	assert.Equals(t, "db.Count", db.Count(), 1)
+}
+
+func TestRedactURL(t *testing.T) {
+	u, _ := url.Parse("https://api.github.com/repos/a/b?client_id=id&" +
+		"client_secret=secret&ref=master")
+	assert.Equals(t, "redactURL", redactURL(u),
+		"https://api.github.com/repos/a/b?ref=master")
+
+	u, _ = url.Parse("http://example.com/a")
+	assert.Equals(t, "redactURL", redactURL(u), "http://example.com/a")
+}
@@ -1,11 +1,15 @@
 package gcse
 
 import (
+	"encoding/gob"
 	"log"
+	"math"
+	"math/rand"
 	"strings"
 	"time"
 
 	"github.com/daviddengcn/gddo/doc"
+	"github.com/daviddengcn/go-villa"
 )
 
 /*
@@ -14,6 +18,16 @@ import (
 type CrawlerDB struct {
 	PackageDB *MemDB
 	PersonDB  *MemDB
+	// blacklist of BlackRequest, key: URL, value: BlackEntry
+	BlackDB *MemDB
+	// key: import prefix, value: VanityRepo
+	VanityDB *MemDB
+	// states of submitted packages, key: package, value: SubmitState
+	SubmitDB *MemDB
+	// recent failures of crawling packages, key: package, value: CrawlFailure
+	FailureDB *MemDB
+	// key: repository, e.g. github.com/owner/repo, value: RepoLicense
+	LicenseDB *MemDB
 }
 
 // LoadCrawlerDB loads PackageDB and PersonDB and returns a new *CrawlerDB
@@ -23,11 +37,16 @@ func LoadCrawlerDB() *CrawlerDB {
 	return &CrawlerDB{
 		PackageDB: NewMemDB(CrawlerDBPath, KindPackage),
 		PersonDB:  NewMemDB(CrawlerDBPath, KindPerson),
+		BlackDB:   NewMemDB(CrawlerDBPath, KindBlack),
+		VanityDB:  NewMemDB(CrawlerDBPath, KindVanity),
+		SubmitDB:  NewMemDB(CrawlerDBPath, KindSubmit),
+		FailureDB: NewMemDB(CrawlerDBPath, KindFailure),
+		LicenseDB: NewMemDB(CrawlerDBPath, KindLicense),
 	}
 }
 
-// Sync syncs both PackageDB and PersonDB. Returns error if any of the sync
-// failed.
+// Sync syncs PackageDB, PersonDB, BlackDB, VanityDB, SubmitDB, FailureDB and
+// LicenseDB. Returns error if any of the sync failed.
 func (cdb *CrawlerDB) Sync() error {
 	if err := cdb.PackageDB.Sync(); err != nil {
 		log.Printf("cdb.PackageDB.Sync failed: %v", err)
@@ -37,18 +56,39 @@ func (cdb *CrawlerDB) Sync() error {
 		log.Printf("cdb.PersonDB.Sync failed: %v", err)
 		return err
 	}
+	if err := cdb.BlackDB.Sync(); err != nil {
+		log.Printf("cdb.BlackDB.Sync failed: %v", err)
+		return err
+	}
+	if err := cdb.VanityDB.Sync(); err != nil {
+		log.Printf("cdb.VanityDB.Sync failed: %v", err)
+		return err
+	}
+	if err := cdb.SubmitDB.Sync(); err != nil {
+		log.Printf("cdb.SubmitDB.Sync failed: %v", err)
+		return err
+	}
+	if err := cdb.FailureDB.Sync(); err != nil {
+		log.Printf("cdb.FailureDB.Sync failed: %v", err)
+		return err
+	}
+	if err := cdb.LicenseDB.Sync(); err != nil {
+		log.Printf("cdb.LicenseDB.Sync failed: %v", err)
+		return err
+	}
 
 	return nil
 }
 
 // SchedulePackage schedules a package to be crawled at a specific time.
+// The crawling statistics of the package are kept.
 func (cdb *CrawlerDB) SchedulePackage(pkg string, sTime time.Time,
 	etag string) error {
-	ent := CrawlingEntry{
-		ScheduleTime: sTime,
-		Version:      CrawlerVersion,
-		Etag:         etag,
-	}
+	var ent CrawlingEntry
+	cdb.PackageDB.Get(pkg, &ent)
+	ent.ScheduleTime = sTime
+	ent.Version = CrawlerVersion
+	ent.Etag = etag
 
 	cdb.PackageDB.Put(pkg, ent)
 
@@ -56,14 +96,100 @@ func (cdb *CrawlerDB) SchedulePackage(pkg string, sTime time.Time,
 	return nil
 }
 
+// RetryPackage schedules a package failed to be crawled to be crawled again at
+// sTime. A submitted package is crawled before others only on the first
+// attempt, so that a failing one does not stay ahead of the queue forever.
+func (cdb *CrawlerDB) RetryPackage(pkg string, sTime time.Time,
+	etag string) {
+	var ent CrawlingEntry
+	cdb.PackageDB.Get(pkg, &ent)
+	ent.ScheduleTime = sTime
+	ent.Version = CrawlerVersion
+	ent.Etag = etag
+	ent.Submitted = false
+	cdb.PackageDB.Put(pkg, ent)
+
+	log.Printf("Retry package %s at %v", pkg, sTime)
+}
+
+const (
+	// initial interval between crawls of a package
+	DefaultPackageAge = 10 * 24 * time.Hour
+	// the interval grows by this factor after a crawl finding no changes,
+	// and is halved after one finding changes
+	packageAgeGrowth = 1.5
+	// crawling statistics are halved when Crawls reaches this
+	maxCrawlStats = 20
+)
+
+// nextPackageInterval returns the interval between crawls of a package after
+// a crawl, shorter if the package changed.
+func nextPackageInterval(interval time.Duration, changed bool) time.Duration {
+	if interval <= 0 {
+		interval = DefaultPackageAge
+	}
+	if changed {
+		interval /= 2
+	} else {
+		interval = time.Duration(float64(interval) * packageAgeGrowth)
+	}
+	if interval < CrawlerMinPackageAge {
+		interval = CrawlerMinPackageAge
+	}
+	if interval > CrawlerMaxPackageAge {
+		interval = CrawlerMaxPackageAge
+	}
+	return interval
+}
+
+// importersBoost returns the factor a crawl interval is divided by for a
+// package with some importers: 1 for none, 2 for 9 and 3 for 99.
+func importersBoost(importers int) float64 {
+	return 1 + math.Log10(1+float64(importers))
+}
+
+// PackageCrawled records a successful crawl of a package, changed is false if
+// ErrPackageNotModifed was returned, and schedules the next crawl at an
+// interval adapted to how often the package changes. Packages with more
+// importers are crawled more often. Returns the scheduled time.
+func (cdb *CrawlerDB) PackageCrawled(pkg string, changed bool, etag string,
+	importers int) time.Time {
+	var ent CrawlingEntry
+	cdb.PackageDB.Get(pkg, &ent)
+
+	ent.Interval = nextPackageInterval(ent.Interval, changed)
+	if ent.Crawls++; changed {
+		ent.Changes++
+	}
+	if ent.Crawls >= maxCrawlStats {
+		ent.Crawls, ent.Changes = ent.Crawls/2, ent.Changes/2
+	}
+	ent.Importers = importers
+	ent.Submitted = false
+
+	age := float64(ent.Interval) / importersBoost(importers) *
+		(1 + (rand.Float64()-0.5)*0.2)
+	if age < float64(CrawlerMinPackageAge) {
+		age = float64(CrawlerMinPackageAge)
+	}
+	ent.ScheduleTime = time.Now().Add(time.Duration(age))
+	ent.Version = CrawlerVersion
+	ent.Etag = etag
+	cdb.PackageDB.Put(pkg, ent)
+
+	log.Printf("Schedule package %s to %v, %d changes in %d crawls", pkg,
+		ent.ScheduleTime, ent.Changes, ent.Crawls)
+	return ent.ScheduleTime
+}
+
 // AppendPackage appends a package. If the package did not exist in either
-// PackageDB or Docs, shedulet it (immediately).
+// PackageDB or Docs, shedulet it (immediately). Banned packages are ignored.
 func (cdb *CrawlerDB) AppendPackage(pkg string,
 	inDocs func(pkg string) bool) {
 	pkg = strings.TrimFunc(strings.TrimSpace(pkg), func(r rune) bool {
 		return r > rune(128)
 	})
-	if !doc.IsValidRemotePath(pkg) {
+	if !doc.IsValidRemotePath(pkg) || IsBanned(pkg) {
 		return
 	}
 
@@ -80,6 +206,63 @@ func (cdb *CrawlerDB) AppendPackage(pkg string,
 	cdb.SchedulePackage(pkg, time.Now(), "")
 }
 
+// SubmitPackage schedules a package submitted by a user to be crawled
+// immediately, before other packages.
+func (cdb *CrawlerDB) SubmitPackage(pkg string) {
+	pkg = strings.TrimSpace(pkg)
+	if !doc.IsValidRemotePath(pkg) {
+		cdb.SetSubmitState(pkg, SubmitInvalid, "not a valid import path")
+		return
+	}
+	if IsBanned(pkg) {
+		cdb.SetSubmitState(pkg, SubmitInvalid, "banned")
+		return
+	}
+	cdb.SetSubmitState(pkg, SubmitQueued, "")
+
+	var ent CrawlingEntry
+	cdb.PackageDB.Get(pkg, &ent)
+	ent.ScheduleTime = time.Now()
+	if ent.Version != CrawlerVersion {
+		ent.Version, ent.Etag = CrawlerVersion, ""
+	}
+	ent.Submitted = true
+	cdb.PackageDB.Put(pkg, ent)
+
+	log.Printf("Package %s submitted", pkg)
+}
+
+// ProcessImports submits the packages in the done segments of ImportSegments,
+// written by the server at /add and /admin, and removes the segments. It is
+// called by tocrawl before generating the crawling list, so that the packages
+// are crawled by the next run of the crawler, and by the crawler at the end
+// of a run.
+func (cdb *CrawlerDB) ProcessImports() error {
+	dones, err := ImportSegments.ListDones()
+	if err != nil {
+		return err
+	}
+
+	for _, segm := range dones {
+		log.Printf("Processing done segment %v ...", segm)
+		pkgs, err := ReadPackages(segm)
+		if err != nil {
+			log.Printf("ReadPackages %v failed: %v", segm, err)
+		}
+		if len(pkgs) > 0 {
+			log.Printf("Importing %d packages ...", len(pkgs))
+			for _, pkg := range pkgs {
+				cdb.SubmitPackage(pkg)
+			}
+		}
+		if err := segm.Remove(); err != nil {
+			log.Printf("Remove %v failed: %v", segm, err)
+		}
+	}
+
+	return nil
+}
+
 // SchedulePerson schedules a person to be crawled at a specific time.
 func (cdb *CrawlerDB) SchedulePerson(id string, sTime time.Time) error {
 	ent := CrawlingEntry{
@@ -107,3 +290,141 @@ func (cdb *CrawlerDB) AppendPerson(site, username string) bool {
 
 	return cdb.SchedulePerson(id, time.Now()) == nil
 }
+
+// ResolveVanity resolves the repository of a package of a custom import path
+// and registers it by RegisterVanityRepo. Resolved repositories, including
+// the negative results, are cached in VanityDB for a while.
+func (cdb *CrawlerDB) ResolveVanity(httpClient doc.HttpClient, pkg string) {
+	if !IsVanityPackage(pkg) || IsLocalPackage(pkg) {
+		return
+	}
+	for _, prefix := range vanityPrefixes(pkg) {
+		var repo VanityRepo
+		if cdb.VanityDB.Get(prefix, &repo) &&
+			time.Now().Before(repo.Resolved.Add(vanityTTL)) {
+			return
+		}
+	}
+
+	repo, err := ResolveVanity(httpClient, pkg)
+	if err != nil {
+		log.Printf("ResolveVanity(%s) failed: %v", pkg, err)
+		return
+	}
+	if repo == nil {
+		// cache the negative result
+		repo = &VanityRepo{ImportPrefix: pkg}
+	}
+	repo.Resolved = time.Now()
+	cdb.VanityDB.Put(repo.ImportPrefix, *repo)
+	RegisterVanityRepo(repo)
+	log.Printf("Vanity repository of %s: %+v", pkg, *repo)
+}
+
+// time a RepoLicense in CrawlerDB.LicenseDB is used without checking the
+// repository again
+const licenseTTL = 7 * 24 * time.Hour
+
+// RepoLicense is the license of a repository, with the ETag of the listing of
+// its root by which the license is checked again.
+type RepoLicense struct {
+	License string
+	Etag    string
+	Checked time.Time
+}
+
+func init() {
+	gob.Register(RepoLicense{})
+}
+
+// PackageLicense returns the license of the repository of a package on
+// GitHub, shared by all packages in it. The license is cached in LicenseDB and
+// fetched by FetchLicense only after licenseTTL and if the repository changed.
+// Packages on other hosts only have the license found in their README by
+// CrawlPackage.
+func (cdb *CrawlerDB) PackageLicense(httpClient doc.HttpClient,
+	pkg string) string {
+	repo := githubRepoOfPackage(pkg)
+	if repo == "" {
+		return ""
+	}
+	var lic RepoLicense
+	if cdb.LicenseDB.Get(repo, &lic) &&
+		time.Now().Before(lic.Checked.Add(licenseTTL)) {
+		return lic.License
+	}
+
+	license, etag, err := FetchLicense(httpClient, pkg, lic.Etag)
+	switch err {
+	case nil:
+		lic.License, lic.Etag = license, etag
+	case ErrPackageNotModifed:
+	default:
+		log.Printf("FetchLicense(%s) failed: %v", pkg, err)
+		return lic.License
+	}
+	lic.Checked = time.Now()
+	cdb.LicenseDB.Put(repo, lic)
+	return lic.License
+}
+
+// time a CrawlFailure is kept in CrawlerDB.FailureDB
+const crawlFailureTTL = 7 * 24 * time.Hour
+
+// CrawlFailure is a failure of crawling a package.
+type CrawlFailure struct {
+	Package string
+	Error   string
+	Time    time.Time
+}
+
+func init() {
+	gob.Register(CrawlFailure{})
+}
+
+// CrawlFailed records a failure of crawling a package in FailureDB.
+func (cdb *CrawlerDB) CrawlFailed(pkg string, err error) {
+	cdb.FailureDB.Put(pkg, CrawlFailure{
+		Package: pkg,
+		Error:   err.Error(),
+		Time:    time.Now(),
+	})
+}
+
+// PurgeCrawlFailures removes the CrawlFailures older than crawlFailureTTL.
+// Returns the number of entries removed.
+func PurgeCrawlFailures(db *MemDB) int {
+	var expired []string
+	before := time.Now().Add(-crawlFailureTTL)
+	db.Iterate(func(pkg string, val interface{}) error {
+		if f, ok := val.(CrawlFailure); !ok || f.Time.Before(before) {
+			expired = append(expired, pkg)
+		}
+		return nil
+	})
+	for _, pkg := range expired {
+		db.Delete(pkg)
+	}
+	return len(expired)
+}
+
+// RecentCrawlFailures returns at most n CrawlFailures in db, the most recent
+// first.
+func RecentCrawlFailures(db *MemDB, n int) []CrawlFailure {
+	var failures []CrawlFailure
+	db.Iterate(func(_ string, val interface{}) error {
+		if f, ok := val.(CrawlFailure); ok {
+			failures = append(failures, f)
+		}
+		return nil
+	})
+	villa.SortF(len(failures), func(i, j int) bool {
+		return failures[i].Time.After(failures[j].Time)
+	}, func(i, j int) {
+		failures[i], failures[j] = failures[j], failures[i]
+	})
+	if len(failures) > n {
+		failures = failures[:n]
+	}
+	return failures
+}
@@ -0,0 +1,87 @@
+package gcse
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/daviddengcn/go-assert"
+)
+
+func TestNextPackageInterval(t *testing.T) {
+	assert.Equals(t, "initial changed", nextPackageInterval(0, true),
+		DefaultPackageAge/2)
+	assert.Equals(t, "initial unchanged", nextPackageInterval(0, false),
+		DefaultPackageAge*3/2)
+	assert.Equals(t, "min", nextPackageInterval(CrawlerMinPackageAge, true),
+		CrawlerMinPackageAge)
+	assert.Equals(t, "max", nextPackageInterval(CrawlerMaxPackageAge, false),
+		CrawlerMaxPackageAge)
+}
+
+func TestCrawlerDB_PackageCrawled(t *testing.T) {
+	cdb := &CrawlerDB{PackageDB: NewMemDB("", "")}
+	pkg := "github.com/daviddengcn/gcse"
+
+	cdb.PackageCrawled(pkg, false, "a", 0)
+	cdb.PackageCrawled(pkg, false, "a", 0)
+	next := cdb.PackageCrawled(pkg, true, "b", 0)
+
+	var ent CrawlingEntry
+	assert.IsTrue(t, "Get", cdb.PackageDB.Get(pkg, &ent))
+	assert.Equals(t, "Crawls", ent.Crawls, 3)
+	assert.Equals(t, "Changes", ent.Changes, 1)
+	assert.Equals(t, "Etag", ent.Etag, "b")
+	assert.Equals(t, "Interval", ent.Interval, DefaultPackageAge*9/8)
+	assert.Equals(t, "ScheduleTime", ent.ScheduleTime, next)
+
+	// statistics are kept when rescheduled
+	cdb.SchedulePackage(pkg, time.Now(), "")
+	assert.IsTrue(t, "Get", cdb.PackageDB.Get(pkg, &ent))
+	assert.Equals(t, "Crawls", ent.Crawls, 3)
+
+	// popular packages are crawled earlier
+	popular := "github.com/daviddengcn/go-villa"
+	cdb.PackageCrawled(popular, true, "c", 99)
+	assert.IsTrue(t, "popular",
+		cdb.PackageCrawled(popular, false, "c", 99).Before(next))
+}
+
+func TestCrawlerDB_RetryPackage(t *testing.T) {
+	cdb := &CrawlerDB{
+		PackageDB: NewMemDB("", ""),
+		SubmitDB:  NewMemDB("", ""),
+	}
+	pkg := "github.com/daviddengcn/gcse"
+	cdb.SubmitPackage(pkg)
+
+	var ent CrawlingEntry
+	assert.IsTrue(t, "Get", cdb.PackageDB.Get(pkg, &ent))
+	assert.IsTrue(t, "Submitted", ent.Submitted)
+
+	// a failed submission is retried as other packages
+	retry := time.Now().Add(time.Hour)
+	cdb.RetryPackage(pkg, retry, "a")
+	assert.IsTrue(t, "Get", cdb.PackageDB.Get(pkg, &ent))
+	assert.Equals(t, "Submitted", ent.Submitted, false)
+	assert.Equals(t, "ScheduleTime", ent.ScheduleTime, retry)
+	assert.Equals(t, "Etag", ent.Etag, "a")
+}
+
+func TestCrawlFailures(t *testing.T) {
+	cdb := &CrawlerDB{FailureDB: NewMemDB("", "")}
+	cdb.CrawlFailed("github.com/a/b", errors.New("b failed"))
+	cdb.CrawlFailed("github.com/a/c", errors.New("c failed"))
+	cdb.FailureDB.Put("github.com/a/d", CrawlFailure{
+		Package: "github.com/a/d",
+		Time:    time.Now().Add(-crawlFailureTTL - time.Hour),
+	})
+
+	failures := RecentCrawlFailures(cdb.FailureDB, 2)
+	assert.Equals(t, "len(failures)", len(failures), 2)
+	assert.IsFalse(t, "most recent first",
+		failures[0].Time.Before(failures[1].Time))
+
+	assert.Equals(t, "PurgeCrawlFailures", PurgeCrawlFailures(cdb.FailureDB), 1)
+	assert.Equals(t, "FailureDB.Count", cdb.FailureDB.Count(), 2)
+}
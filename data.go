@@ -19,6 +19,8 @@ type DocInfo struct {
 	Package     string
 	Author      string
 	LastUpdated time.Time
+	// the time the package was first merged into docs, set by mergedocs
+	FirstSeen   time.Time
 	StarCount   int
 	Synopsis    string
 	Description string
@@ -27,7 +29,43 @@ type DocInfo struct {
 	ReadmeData  string
 	Imports     []string
 	TestImports []string
-	Exported    []string // exported tokens(funcs/types)
+	Exported    []string // exported symbols(funcs/types/consts/vars/methods)
+	Examples    []Example
+	License     string // SPDX identifier, empty if unknown
+}
+
+// LegacyFirstSeen is the FirstSeen of the docs merged before FirstSeen was
+// introduced. It is before any index, so that those packages are not new.
+var LegacyFirstSeen = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Example is a code example of a package.
+type Example struct {
+	// Name of the example function without the "Example" prefix, e.g. "",
+	// "Func", "Type_Method" or "Func_suffix"
+	Name   string
+	Doc    string
+	Code   string
+	Output string
+}
+
+// Source returns the example as a runnable example function.
+func (e Example) Source() string {
+	lines := strings.Split(strings.TrimRight(e.Code, "\n"), "\n")
+	src := "func Example" + e.Name + "() {\n"
+	for _, line := range lines {
+		if line != "" {
+			line = "\t" + line
+		}
+		src += line + "\n"
+	}
+	if e.Output != "" {
+		src += "\t// Output:\n"
+		output := strings.Split(strings.TrimRight(e.Output, "\n"), "\n")
+		for _, line := range output {
+			src += "\t// " + line + "\n"
+		}
+	}
+	return src + "}"
 }
 
 // Returns a new instance of DocInfo as a sophie.Sophier
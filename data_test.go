@@ -15,6 +15,7 @@ func TestDocInfo(t *testing.T) {
 		Package:     "github.com/daviddengcn/gcse",
 		Author:      "github.com/daviddengcn",
 		LastUpdated: time.Now(),
+		FirstSeen:   time.Now().Add(-time.Hour),
 		StarCount:   10,
 		Synopsis:    "Go Package Search Engine",
 		Description: "More details about GCSE",
@@ -60,3 +61,19 @@ func TestCheckRuneType_BOM(t *testing.T) {
 	tp := CheckRuneType('A', 0xfeff)
 	assert.Equals(t, "CheckRuneType(0, 0xfeff)", tp, index.TokenSep)
 }
+
+func TestExample_Source(t *testing.T) {
+	ex := Example{
+		Name:   "Index",
+		Code:   "fmt.Println(1)\n\nfmt.Println(2)\n",
+		Output: "1\n2\n",
+	}
+	assert.Equals(t, "Source", ex.Source(), `func ExampleIndex() {
+	fmt.Println(1)
+
+	fmt.Println(2)
+	// Output:
+	// 1
+	// 2
+}`)
+}
@@ -0,0 +1,273 @@
+package gcse
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/daviddengcn/gddo/doc"
+)
+
+const (
+	githubAPIHost = "api.github.com"
+	githubRateURL = "https://api.github.com/rate_limit"
+)
+
+// GithubBudget is the rate limit budget of a GitHub credential.
+type GithubBudget struct {
+	// Name of the credential, with tokens masked.
+	Name string
+	// -1 if unknown
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// available returns whether a request can be sent with the credential at now.
+func (b *GithubBudget) available(now time.Time) bool {
+	return b.Remaining != 0 || !now.Before(b.Reset)
+}
+
+func (b GithubBudget) String() string {
+	if b.Remaining < 0 {
+		return b.Name + ": unknown"
+	}
+	return fmt.Sprintf("%s: %d/%d, reset in %v", b.Name, b.Remaining,
+		b.Limit, b.Reset.Sub(time.Now())/time.Second*time.Second)
+}
+
+// GithubAuth is a doc.HttpClient authorizing requests to the GitHub API with
+// GithubTokens, switching to the next token when the rate limit of the
+// current one is exhausted. Without tokens, GithubClientID and
+// GithubClientSecret are added to the query if not yet added by gddo. The
+// budgets of the credentials are tracked from the X-RateLimit-* headers of
+// responses.
+//
+// If more than one token is used, X-RateLimit-Remaining and X-RateLimit-Reset
+// of responses are replaced with the budget of all tokens so that
+// HostScheduler does not wait for the reset of a single token.
+type GithubAuth struct {
+	client       doc.HttpClient
+	tokens       []string
+	clientID     string
+	clientSecret string
+
+	mu      sync.Mutex
+	budgets []GithubBudget
+	cur     int
+}
+
+// maskToken returns a token with all but the last 4 characters masked.
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return "****"
+	}
+	return "****" + token[len(token)-4:]
+}
+
+// NewGithubAuth returns a GithubAuth sending requests by client with the
+// configured GitHub credentials.
+func NewGithubAuth(client doc.HttpClient) *GithubAuth {
+	auth := &GithubAuth{
+		client:       client,
+		tokens:       GithubTokens,
+		clientID:     GithubClientID,
+		clientSecret: GithubClientSecret,
+	}
+	if len(auth.tokens) == 0 {
+		name := "anonymous"
+		if auth.clientID != "" {
+			name = "client " + auth.clientID
+		}
+		auth.budgets = []GithubBudget{{Name: name, Limit: -1, Remaining: -1}}
+	}
+	for i, token := range auth.tokens {
+		auth.budgets = append(auth.budgets, GithubBudget{
+			Name:      fmt.Sprintf("token #%d(%s)", i+1, maskToken(token)),
+			Limit:     -1,
+			Remaining: -1,
+		})
+	}
+	return auth
+}
+
+// pick returns the index of the credential to use, the current one if
+// available, or else the next available one, or else the one reset first.
+func (a *GithubAuth) pick(now time.Time) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	best := a.cur
+	for i := range a.budgets {
+		idx := (a.cur + i) % len(a.budgets)
+		if a.budgets[idx].available(now) {
+			best = idx
+			break
+		}
+		if a.budgets[idx].Reset.Before(a.budgets[best].Reset) {
+			best = idx
+		}
+	}
+	if best != a.cur {
+		log.Printf("Switch GitHub credential to %s", a.budgets[best].Name)
+		a.cur = best
+	}
+	return best
+}
+
+// update updates the budget of a credential by the headers of a response.
+func (a *GithubAuth) update(idx int, h http.Header) {
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	b := &a.budgets[idx]
+	b.Remaining = remaining
+	if limit, err := strconv.Atoi(h.Get("X-RateLimit-Limit")); err == nil {
+		b.Limit = limit
+	}
+	if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10,
+		64); err == nil {
+		b.Reset = time.Unix(reset, 0)
+	}
+}
+
+// poolHeader replaces the X-RateLimit-* headers with the budget of all
+// tokens. A token with unknown budget counts as one remaining request.
+func (a *GithubAuth) poolHeader(h http.Header, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	remaining, reset := 0, time.Time{}
+	for _, b := range a.budgets {
+		switch {
+		case b.Remaining < 0 || !now.Before(b.Reset):
+			remaining++
+		default:
+			remaining += b.Remaining
+		}
+		if b.Remaining == 0 && (reset.IsZero() || b.Reset.Before(reset)) {
+			reset = b.Reset
+		}
+	}
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if !reset.IsZero() {
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	}
+}
+
+// authorize returns a copy of req authorized with the idx-th credential.
+func (a *GithubAuth) authorize(req *http.Request, idx int) *http.Request {
+	if len(a.tokens) == 0 {
+		if a.clientID == "" || req.URL.Query().Get("client_id") != "" {
+			return req
+		}
+		r, u := *req, *req.URL
+		q := u.Query()
+		q.Set("client_id", a.clientID)
+		q.Set("client_secret", a.clientSecret)
+		u.RawQuery = q.Encode()
+		r.URL = &u
+		return &r
+	}
+	r := *req
+	r.Header = make(http.Header)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "token "+a.tokens[idx])
+	return &r
+}
+
+// Do implements doc.HttpClient.
+func (a *GithubAuth) Do(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != githubAPIHost {
+		return a.client.Do(req)
+	}
+	for tries := 0; ; tries++ {
+		now := time.Now()
+		idx := a.pick(now)
+		resp, err := a.client.Do(a.authorize(req, idx))
+		if err != nil {
+			return resp, err
+		}
+		a.update(idx, resp.Header)
+		if len(a.tokens) <= 1 {
+			return resp, nil
+		}
+		if resp.StatusCode == http.StatusForbidden &&
+			resp.Header.Get("X-RateLimit-Remaining") == "0" &&
+			tries+1 < len(a.tokens) && req.Method == "GET" {
+			// retry with the next token if any is available
+			if next := a.pick(now); next != idx {
+				resp.Body.Close()
+				continue
+			}
+		}
+		a.poolHeader(resp.Header, now)
+		return resp, nil
+	}
+}
+
+// Validate checks the credentials by requesting the rate limit, which is not
+// counted in the rate limit, of each of them. Returns an error only if any of
+// them is rejected, i.e. 401. Other failures, e.g. of the network, are logged
+// and the credential is kept.
+func (a *GithubAuth) Validate() error {
+	for i := range a.budgets {
+		req, err := http.NewRequest("GET", githubRateURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := a.client.Do(a.authorize(req, i))
+		if err != nil {
+			log.Printf("Validating GitHub %s failed: %s", a.budgets[i].Name,
+				redactError(err))
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("GitHub %s is rejected: %s", a.budgets[i].Name,
+				resp.Status)
+		}
+		a.update(i, resp.Header)
+	}
+	return nil
+}
+
+// Budgets returns the rate limit budgets of the credentials.
+func (a *GithubAuth) Budgets() []GithubBudget {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]GithubBudget(nil), a.budgets...)
+}
+
+// SaveBudgets saves the rate limit budgets of the credentials into
+// FnGithubBudgets in CrawlerDBPath, shown by the server at /admin.
+func (a *GithubAuth) SaveBudgets() error {
+	return WriteJsonFile(CrawlerDBPath.Join(FnGithubBudgets), a.Budgets())
+}
+
+// LoadGithubBudgets loads the budgets saved by GithubAuth.SaveBudgets.
+func LoadGithubBudgets() ([]GithubBudget, error) {
+	var budgets []GithubBudget
+	if err := ReadJsonFile(CrawlerDBPath.Join(FnGithubBudgets),
+		&budgets); err != nil {
+		return nil, err
+	}
+	return budgets, nil
+}
+
+// LogBudgets logs the rate limit budgets of the credentials.
+func (a *GithubAuth) LogBudgets() {
+	for _, b := range a.Budgets() {
+		log.Printf("GitHub rate limit of %v", b)
+	}
+}
@@ -0,0 +1,125 @@
+package gcse
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-villa"
+)
+
+func TestGithubAuth(t *testing.T) {
+	defer func(tokens []string) { GithubTokens = tokens }(GithubTokens)
+	GithubTokens = []string{"token-a", "token-b"}
+
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	remaining := map[string]int{"token token-a": 1, "token token-b": 5}
+	var auths []string
+	auth := NewGithubAuth(httpClientFunc(func(req *http.Request) (
+		*http.Response, error) {
+		a := req.Header.Get("Authorization")
+		auths = append(auths, a)
+		status := http.StatusOK
+		if remaining[a] == 0 {
+			status = http.StatusForbidden
+		} else {
+			remaining[a]--
+		}
+		return &http.Response{
+			StatusCode: status,
+			Header: http.Header{
+				"X-Ratelimit-Limit":     {"5000"},
+				"X-Ratelimit-Remaining": {strconv.Itoa(remaining[a])},
+				"X-Ratelimit-Reset":     {reset},
+			},
+			Body: villa.NewPByteSlice(nil),
+		}, nil
+	}))
+
+	req, _ := http.NewRequest("GET", "https://api.github.com/users/a", nil)
+	resp, err := auth.Do(req)
+	assert.NoErrorf(t, "auth.Do: %v", err)
+	assert.Equals(t, "StatusCode", resp.StatusCode, http.StatusOK)
+	// token-a is exhausted, token-b is unknown
+	assert.Equals(t, "Remaining", resp.Header.Get("X-RateLimit-Remaining"),
+		"1")
+
+	resp, err = auth.Do(req)
+	assert.NoErrorf(t, "auth.Do: %v", err)
+	assert.Equals(t, "StatusCode", resp.StatusCode, http.StatusOK)
+	assert.Equals(t, "Remaining", resp.Header.Get("X-RateLimit-Remaining"),
+		"4")
+	assert.StringEquals(t, "auths", auths,
+		[]string{"token token-a", "token token-b"})
+	assert.Equals(t, "req.Header", req.Header.Get("Authorization"), "")
+
+	budgets := auth.Budgets()
+	assert.Equals(t, "len(budgets)", len(budgets), 2)
+	assert.Equals(t, "budgets[0].Remaining", budgets[0].Remaining, 0)
+	assert.Equals(t, "budgets[1].Remaining", budgets[1].Remaining, 4)
+	assert.Equals(t, "budgets[1].Limit", budgets[1].Limit, 5000)
+}
+
+func TestGithubAuthValidate(t *testing.T) {
+	defer func(tokens []string) { GithubTokens = tokens }(GithubTokens)
+	GithubTokens = []string{"token-a", "token-b"}
+
+	status := map[string]int{"token token-a": http.StatusOK}
+	auth := NewGithubAuth(httpClientFunc(func(req *http.Request) (
+		*http.Response, error) {
+		st, ok := status[req.Header.Get("Authorization")]
+		if !ok {
+			return nil, errors.New("network is down")
+		}
+		return &http.Response{
+			StatusCode: st,
+			Header:     make(http.Header),
+			Body:       villa.NewPByteSlice(nil),
+		}, nil
+	}))
+	// failures of the network are not fatal
+	assert.NoErrorf(t, "Validate: %v", auth.Validate())
+
+	status["token token-b"] = http.StatusUnauthorized
+	assert.Equals(t, "Validate rejected", auth.Validate() != nil, true)
+}
+
+func TestGithubAuthClient(t *testing.T) {
+	defer func(tokens []string, id, secret string) {
+		GithubTokens, GithubClientID, GithubClientSecret = tokens, id, secret
+	}(GithubTokens, GithubClientID, GithubClientSecret)
+	GithubTokens, GithubClientID, GithubClientSecret = nil, "id", "secret"
+
+	var queries []string
+	auth := NewGithubAuth(httpClientFunc(func(req *http.Request) (
+		*http.Response, error) {
+		queries = append(queries, req.URL.RawQuery)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       villa.NewPByteSlice(nil),
+		}, nil
+	}))
+	req, _ := http.NewRequest("GET", "https://api.github.com/repos/a/b", nil)
+	_, err := auth.Do(req)
+	assert.NoErrorf(t, "auth.Do: %v", err)
+	assert.Equals(t, "req.URL", req.URL.RawQuery, "")
+	// added by gddo
+	req, _ = http.NewRequest("GET",
+		"https://api.github.com/repos/a/b?client_id=id&client_secret=secret",
+		nil)
+	_, err = auth.Do(req)
+	assert.NoErrorf(t, "auth.Do: %v", err)
+	assert.StringEquals(t, "queries", queries, []string{
+		"client_id=id&client_secret=secret",
+		"client_id=id&client_secret=secret",
+	})
+}
+
+func TestMaskToken(t *testing.T) {
+	assert.Equals(t, "short", maskToken("abc"), "****")
+	assert.Equals(t, "long", maskToken("0123456789"), "****6789")
+}
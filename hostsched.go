@@ -0,0 +1,185 @@
+package gcse
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daviddengcn/gddo/doc"
+	"github.com/daviddengcn/go-villa"
+)
+
+// ErrCrawlDeadline is returned by HostScheduler.Do if the request cannot be
+// sent before the deadline.
+var ErrCrawlDeadline = errors.New("crawl deadline exceeded")
+
+// base of the exponential backoff after errors of a host
+const hostBackoffBase = 10 * time.Second
+
+type hostState struct {
+	// serializes requests to the host
+	sync.Mutex
+	// the earliest time of the next request
+	next time.Time
+	// number of consecutive failures
+	failures int
+	// whether a request has failed with ErrCrawlDeadline
+	expired bool
+}
+
+// HostScheduler is a doc.HttpClient sending requests to each host no faster
+// than the rate in CrawlerHostRates. Requests to a host wait for the
+// Retry-After of the last response and the reset time of an exhausted GitHub
+// rate limit. Consecutive errors, including 5xx and 429 responses, delay the
+// next request by an exponential backoff with jitter.
+type HostScheduler struct {
+	client   doc.HttpClient
+	deadline time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// NewHostScheduler returns a HostScheduler sending requests by client.
+// Requests which cannot be sent before deadline fail with ErrCrawlDeadline.
+// A zero deadline means no deadline.
+func NewHostScheduler(client doc.HttpClient,
+	deadline time.Time) *HostScheduler {
+	return &HostScheduler{
+		client:   client,
+		deadline: deadline,
+		hosts:    make(map[string]*hostState),
+	}
+}
+
+func (s *HostScheduler) host(host string) *hostState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hs, ok := s.hosts[host]
+	if !ok {
+		hs = &hostState{}
+		s.hosts[host] = hs
+	}
+	return hs
+}
+
+// Expired returns true if the deadline has passed, after which no request is
+// sent to any host.
+func (s *HostScheduler) Expired() bool {
+	return !s.deadline.IsZero() && time.Now().After(s.deadline)
+}
+
+// HostExpired returns true if a request to a host has failed with
+// ErrCrawlDeadline, e.g. waiting for the reset of a rate limit, so that no
+// more requests are sent to it. Requests to other hosts are not affected.
+func (s *HostScheduler) HostExpired(host string) bool {
+	hs := s.host(host)
+	hs.Lock()
+	defer hs.Unlock()
+
+	return hs.expired
+}
+
+// IsCrawlDeadline returns whether an error returned by crawling, possibly
+// wrapped by gddo, is ErrCrawlDeadline.
+func IsCrawlDeadline(err error) bool {
+	return err != nil && (villa.DeepestNested(err) == ErrCrawlDeadline ||
+		strings.Contains(err.Error(), ErrCrawlDeadline.Error()))
+}
+
+func hostInterval(host string) time.Duration {
+	rate, ok := CrawlerHostRates[host]
+	if !ok {
+		rate = CrawlerHostRates[DefaultHostRate]
+	}
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// backoff returns the delay after the n-th consecutive failure, a random
+// duration in [d/2, d] where d doubles for each failure up to
+// CrawlerMaxBackoff.
+func backoff(n int) time.Duration {
+	d := hostBackoffBase
+	for i := 1; i < n && d < CrawlerMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > CrawlerMaxBackoff {
+		d = CrawlerMaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryDelay returns the duration the server asks to wait before the next
+// request, from Retry-After or X-RateLimit-* headers of GitHub. Returns zero
+// if not specified.
+func retryDelay(h http.Header, now time.Time) time.Duration {
+	if ra := h.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(ra); err == nil {
+			return t.Sub(now)
+		}
+	}
+	if h.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10,
+			64); err == nil {
+			return time.Unix(reset, 0).Sub(now)
+		}
+	}
+	return 0
+}
+
+// Do implements doc.HttpClient.
+func (s *HostScheduler) Do(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	hs := s.host(host)
+	hs.Lock()
+	defer hs.Unlock()
+
+	if !s.deadline.IsZero() && (time.Now().After(s.deadline) ||
+		hs.next.After(s.deadline)) {
+		if !hs.expired {
+			hs.expired = true
+			log.Printf("No more requests to %s before the deadline %v", host,
+				s.deadline)
+		}
+		return nil, ErrCrawlDeadline
+	}
+	if wait := hs.next.Sub(time.Now()); wait > 0 {
+		log.Printf("Wait %v before requesting %s", wait, host)
+		time.Sleep(wait)
+	}
+
+	resp, err := s.client.Do(req)
+	now := time.Now()
+	next := now.Add(hostInterval(host))
+	if err != nil || resp.StatusCode >= 500 ||
+		resp.StatusCode == http.StatusTooManyRequests {
+		hs.failures++
+		if b := now.Add(backoff(hs.failures)); b.After(next) {
+			next = b
+		}
+		log.Printf("%d consecutive failures of %s, next request after %v",
+			hs.failures, host, next.Sub(now))
+	} else {
+		hs.failures = 0
+	}
+	if resp != nil {
+		if d := retryDelay(resp.Header, now); d > 0 && now.Add(d).After(next) {
+			next = now.Add(d)
+			log.Printf("%s asks to retry after %v", host, d)
+		}
+	}
+	hs.next = next
+	return resp, err
+}
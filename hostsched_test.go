@@ -0,0 +1,90 @@
+package gcse
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-villa"
+)
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Now()
+	assert.Equals(t, "none", retryDelay(http.Header{}, now), time.Duration(0))
+	assert.Equals(t, "Retry-After", retryDelay(http.Header{
+		"Retry-After": {"120"},
+	}, now), 2*time.Minute)
+	assert.Equals(t, "X-RateLimit", retryDelay(http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(now.Unix()+60, 10)},
+	}, time.Unix(now.Unix(), 0)), time.Minute)
+	assert.Equals(t, "X-RateLimit remaining", retryDelay(http.Header{
+		"X-Ratelimit-Remaining": {"10"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(now.Unix()+60, 10)},
+	}, now), time.Duration(0))
+}
+
+func TestBackoff(t *testing.T) {
+	for n := 1; n < 40; n++ {
+		d := CrawlerMaxBackoff
+		if n < 20 && hostBackoffBase<<uint(n-1) < d {
+			d = hostBackoffBase << uint(n-1)
+		}
+		b := backoff(n)
+		if b < d/2 || b > d {
+			t.Errorf("backoff(%d) = %v, expected in [%v, %v]", n, b, d/2, d)
+		}
+	}
+}
+
+type httpClientFunc func(req *http.Request) (*http.Response, error)
+
+func (f httpClientFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHostScheduler(t *testing.T) {
+	sched := NewHostScheduler(httpClientFunc(func(req *http.Request) (
+		*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     http.Header{"Retry-After": {"3600"}},
+		}, nil
+	}), time.Now().Add(time.Minute))
+
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Scheme: "http", Host: "example.com", Path: "/"},
+	}
+	resp, err := sched.Do(req)
+	assert.NoErrorf(t, "first Do: %v", err)
+	assert.Equals(t, "StatusCode", resp.StatusCode,
+		http.StatusServiceUnavailable)
+	assert.IsFalse(t, "Expired", sched.Expired())
+
+	// the next request has to wait for an hour which exceeds the deadline
+	_, err = sched.Do(req)
+	assert.Equals(t, "second Do", err, ErrCrawlDeadline)
+	assert.IsTrue(t, "HostExpired", sched.HostExpired("example.com"))
+	// other hosts are not affected
+	assert.IsFalse(t, "Expired", sched.Expired())
+	assert.IsFalse(t, "HostExpired", sched.HostExpired("example.org"))
+	req.URL.Host = "example.org"
+	_, err = sched.Do(req)
+	assert.NoErrorf(t, "Do of another host: %v", err)
+
+	// no request is sent after the deadline
+	sched = NewHostScheduler(httpClientFunc(func(req *http.Request) (
+		*http.Response, error) {
+		t.Errorf("request sent after the deadline")
+		return nil, nil
+	}), time.Now().Add(-time.Second))
+	_, err = sched.Do(req)
+	assert.Equals(t, "Do after deadline", err, ErrCrawlDeadline)
+	assert.IsTrue(t, "Expired", sched.Expired())
+	assert.IsTrue(t, "IsCrawlDeadline", IsCrawlDeadline(
+		villa.NestErrorf(ErrCrawlDeadline, "crawling")))
+}
@@ -3,6 +3,7 @@ package gcse
 import (
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/daviddengcn/go-index"
@@ -15,33 +16,83 @@ const (
 	IndexTextField = "text"
 	IndexNameField = "name"
 	IndexPkgField  = "pkg"
+	// exported symbols, matched exactly with case preserved
+	IndexSymbolField = "symbol"
+	// lower-cased SPDX identifier of the license
+	IndexLicenseField = "license"
 )
 
 var errNotDocInfo = errors.New("Value is not DocInfo")
 
-func Index(docDB mr.Input) (*index.TokenSetSearcher, error) {
-	DumpMemStats()
+// ReindexRequest is a request of reindexing written by RequestReindex.
+type ReindexRequest struct {
+	Time time.Time
+}
 
-	docPartCnt, err := docDB.PartCount()
-	if err != nil {
-		return nil, err
+// RequestReindex requests the indexer to index the docs on its next run, even
+// if it runs with -requested.
+func RequestReindex() error {
+	return WriteJsonFile(DataRoot.Join(FnReindexRequest), ReindexRequest{
+		Time: time.Now(),
+	})
+}
+
+// PendingReindexRequest returns the request of reindexing not yet handled by
+// the indexer, nil if none.
+func PendingReindexRequest() *ReindexRequest {
+	var req ReindexRequest
+	if err := ReadJsonFile(DataRoot.Join(FnReindexRequest), &req); err != nil {
+		return nil
 	}
-	docCount := 0
+	return &req
+}
 
-	log.Printf("Generating importsDB ...")
-	importsDB := NewTokenIndexer("", "")
-	testImportsDB := NewTokenIndexer("", "")
+// ClearReindexRequest removes the request of reindexing after indexing.
+func ClearReindexRequest() error {
+	fn := DataRoot.Join(FnReindexRequest)
+	if !fn.Exists() {
+		return nil
+	}
+	return fn.Remove()
+}
+
+// IndexInfo is the meta information saved with the index file in an index
+// segment.
+type IndexInfo struct {
+	// The time when the index of the previous segment was generated. Docs
+	// updated after this time are new in this segment.
+	PrevUpdated time.Time
+}
+
+// prjStar is the star count of a project, of its last updated package.
+type prjStar struct {
+	StarCount   int
+	LastUpdated time.Time
+}
+
+// indexStats is the information over all docs needed to make the HitInfo of a
+// doc.
+type indexStats struct {
+	importsDB     *TokenIndexer
+	testImportsDB *TokenIndexer
 	// per project imported by projects
-	prjImportsDB := NewTokenIndexer("", "")
-	prjStars := make(map[string]struct {
-		StarCount   int
-		LastUpdated time.Time
-	})
-	// generate importsDB
+	prjImportsDB *TokenIndexer
+	prjStars     map[string]prjStar
+	docCount     int
+}
+
+// forEachDoc calls f with every doc in docDB not banned by the loaded ban
+// list.
+func forEachDoc(docDB mr.Input,
+	f func(pkg string, docInfo *DocInfo) error) error {
+	docPartCnt, err := docDB.PartCount()
+	if err != nil {
+		return err
+	}
 	for i := 0; i < docPartCnt; i++ {
 		it, err := docDB.Iterator(i)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		var pkg sophie.RawString
@@ -52,148 +103,199 @@ func Index(docDB mr.Input) (*index.TokenSetSearcher, error) {
 					break
 				}
 				it.Close()
-				return nil, err
+				return err
 			}
-			importsDB.Put(string(pkg), villa.NewStrSet(docInfo.Imports...))
-			testImportsDB.Put(string(pkg),
-				villa.NewStrSet(docInfo.TestImports...))
-
-			var projects villa.StrSet
-			for _, imp := range docInfo.Imports {
-				projects.Put(FullProjectOfPackage(imp))
-			}
-			for _, imp := range docInfo.TestImports {
-				projects.Put(FullProjectOfPackage(imp))
+			if IsBanned(string(pkg)) {
+				continue
 			}
-			prj := FullProjectOfPackage(string(pkg))
-			orgProjects := prjImportsDB.TokensOfId(prj)
-			projects.Put(orgProjects...)
-			prjImportsDB.Put(prj, projects)
-
-			// update stars
-			if cur, ok := prjStars[prj]; !ok ||
-				docInfo.LastUpdated.After(cur.LastUpdated) {
-				prjStars[prj] = struct {
-					StarCount   int
-					LastUpdated time.Time
-				}{
-					docInfo.StarCount,
-					docInfo.LastUpdated,
-				}
+			if err := f(string(pkg), &docInfo); err != nil {
+				it.Close()
+				return err
 			}
-
-			docCount++
 		}
 
 		it.Close()
 	}
+	return nil
+}
 
-	DumpMemStats()
-	log.Printf("Making HitInfos ...")
-	hits := make([]HitInfo, 0, docCount)
-	for i := 0; i < docPartCnt; i++ {
-		it, err := docDB.Iterator(i)
-		if err != nil {
-			return nil, err
+func collectIndexStats(docDB mr.Input) (*indexStats, error) {
+	log.Printf("Generating importsDB ...")
+	st := &indexStats{
+		importsDB:     NewTokenIndexer("", ""),
+		testImportsDB: NewTokenIndexer("", ""),
+		prjImportsDB:  NewTokenIndexer("", ""),
+		prjStars:      make(map[string]prjStar),
+	}
+	if err := forEachDoc(docDB, func(pkg string, docInfo *DocInfo) error {
+		st.importsDB.Put(pkg, villa.NewStrSet(docInfo.Imports...))
+		st.testImportsDB.Put(pkg, villa.NewStrSet(docInfo.TestImports...))
+
+		var projects villa.StrSet
+		for _, imp := range docInfo.Imports {
+			projects.Put(FullProjectOfPackage(imp))
+		}
+		for _, imp := range docInfo.TestImports {
+			projects.Put(FullProjectOfPackage(imp))
 		}
+		prj := FullProjectOfPackage(pkg)
+		orgProjects := st.prjImportsDB.TokensOfId(prj)
+		projects.Put(orgProjects...)
+		st.prjImportsDB.Put(prj, projects)
 
-		var pkg sophie.RawString
-		var hitInfo HitInfo
-		for {
-			if err := it.Next(&pkg, &hitInfo.DocInfo); err != nil {
-				if err == sophie.EOF {
-					break
-				}
-				it.Close()
-				return nil, err
+		// update stars
+		if cur, ok := st.prjStars[prj]; !ok ||
+			docInfo.LastUpdated.After(cur.LastUpdated) {
+			st.prjStars[prj] = prjStar{
+				StarCount:   docInfo.StarCount,
+				LastUpdated: docInfo.LastUpdated,
 			}
+		}
 
-			hitInfo.Imported = importsDB.IdsOfToken(hitInfo.Package)
-			hitInfo.TestImported = testImportsDB.IdsOfToken(hitInfo.Package)
-
-			prj := FullProjectOfPackage(hitInfo.Package)
-			impPrjsCnt := len(prjImportsDB.IdsOfToken(prj))
-			var assignedStarCount = float64(prjStars[prj].StarCount)
-			if prj != hitInfo.Package {
-				if impPrjsCnt == 0 {
-					assignedStarCount = 0
-				} else {
-					perStarCount :=
-						float64(prjStars[prj].StarCount) / float64(impPrjsCnt)
-	
-					var projects villa.StrSet
-					for _, imp := range hitInfo.Imported {
-						projects.Put(FullProjectOfPackage(imp))
-					}
-					for _, imp := range hitInfo.TestImported {
-						projects.Put(FullProjectOfPackage(imp))
-					}
-					assignedStarCount = perStarCount * float64(len(projects))
-				}
-			}
-			hitInfo.AssignedStarCount = assignedStarCount
+		st.docCount++
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
 
-			readme := ReadmeToText(hitInfo.ReadmeFn, hitInfo.ReadmeData)
+// scoreHit sets the fields of hit derived from other docs, and the static
+// scores.
+func (st *indexStats) scoreHit(hitInfo *HitInfo) {
+	hitInfo.Imported = st.importsDB.IdsOfToken(hitInfo.Package)
+	hitInfo.TestImported = st.testImportsDB.IdsOfToken(hitInfo.Package)
 
-			hitInfo.ImportantSentences = ChooseImportantSentenses(readme,
-				hitInfo.Name, hitInfo.Package)
-			// StaticScore is calculated after setting all other fields of
-			// hitInfo
-			hitInfo.StaticScore = CalcStaticScore(&hitInfo)
-			hitInfo.TestStaticScore = CalcTestStaticScore(&hitInfo)
+	prj := FullProjectOfPackage(hitInfo.Package)
+	impPrjsCnt := len(st.prjImportsDB.IdsOfToken(prj))
+	var assignedStarCount = float64(st.prjStars[prj].StarCount)
+	if prj != hitInfo.Package {
+		if impPrjsCnt == 0 {
+			assignedStarCount = 0
+		} else {
+			perStarCount :=
+				float64(st.prjStars[prj].StarCount) / float64(impPrjsCnt)
 
-			hits = append(hits, hitInfo)
+			var projects villa.StrSet
+			for _, imp := range hitInfo.Imported {
+				projects.Put(FullProjectOfPackage(imp))
+			}
+			for _, imp := range hitInfo.TestImported {
+				projects.Put(FullProjectOfPackage(imp))
+			}
+			assignedStarCount = perStarCount * float64(len(projects))
 		}
+	}
+	hitInfo.AssignedStarCount = assignedStarCount
 
-		it.Close()
+	// StaticScore is calculated after setting all other fields of hitInfo
+	// it depends on
+	hitInfo.StaticScore = CalcStaticScore(hitInfo)
+	hitInfo.TestStaticScore = CalcTestStaticScore(hitInfo)
+}
+
+// hitInfo returns the HitInfo of a doc without StaticRank.
+func (st *indexStats) hitInfo(docInfo *DocInfo) HitInfo {
+	hitInfo := HitInfo{DocInfo: *docInfo}
+	st.scoreHit(&hitInfo)
+
+	readme := ReadmeToText(hitInfo.ReadmeFn, hitInfo.ReadmeData)
+
+	hitInfo.ImportantSentences = ChooseImportantSentenses(readme,
+		hitInfo.Name, hitInfo.Package)
+	return hitInfo
+}
+
+// sortHits sorts hits by StaticScore in descending order.
+func sortHits(hits []HitInfo) {
+	villa.SortF(len(hits), func(i, j int) bool {
+		return hits[i].StaticScore > hits[j].StaticScore
+	}, func(i, j int) {
+		hits[i], hits[j] = hits[j], hits[i]
+	})
+}
+
+// Index creates a TokenSetSearcher of the docs in docDB. Packages banned by
+// the loaded ban list are skipped.
+func Index(docDB mr.Input) (*index.TokenSetSearcher, error) {
+	DumpMemStats()
+
+	st, err := collectIndexStats(docDB)
+	if err != nil {
+		return nil, err
+	}
+
+	DumpMemStats()
+	log.Printf("Making HitInfos ...")
+	hits := make([]HitInfo, 0, st.docCount)
+	if err := forEachDoc(docDB, func(_ string, docInfo *DocInfo) error {
+		hits = append(hits, st.hitInfo(docInfo))
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 
 	DumpMemStats()
-	importsDB = nil
+	st = nil
 	DumpMemStats()
 	log.Printf("%d hits collected, sorting static-scores in descending order",
 		len(hits))
-	idxs := make([]int, len(hits))
-	for i := range idxs {
-		idxs[i] = i
-	}
-	villa.SortF(len(idxs), func(i, j int) bool {
-		return hits[idxs[i]].StaticScore > hits[idxs[j]].StaticScore
-	}, func(i, j int) {
-		idxs[i], idxs[j] = idxs[j], idxs[i]
-	})
+	sortHits(hits)
 	ts := &index.TokenSetSearcher{}
 
 	DumpMemStats()
 	log.Printf("Indexing to TokenSetSearcher ...")
 	rank := 0
-	for i := range idxs {
-		hit := &hits[idxs[i]]
-		if i > 0 && hit.StaticScore < hits[idxs[i-1]].StaticScore {
+	for i := range hits {
+		hit := &hits[i]
+		if i > 0 && hit.StaticScore < hits[i-1].StaticScore {
 			rank = i
 		}
 		hit.StaticRank = rank
 
-		var nameTokens villa.StrSet
-		nameTokens = AppendTokens(nameTokens, []byte(hit.Name))
-
-		var tokens villa.StrSet
-		tokens.Put(nameTokens.Elements()...)
-		tokens = AppendTokens(tokens, []byte(hit.Package))
-		tokens = AppendTokens(tokens, []byte(hit.Description))
-		tokens = AppendTokens(tokens, []byte(hit.ReadmeData))
-		tokens = AppendTokens(tokens, []byte(hit.Author))
-		for _, word := range hit.Exported {
-			AppendTokens(tokens, []byte(word))
-		}
-
-		ts.AddDoc(map[string]villa.StrSet{
-			IndexTextField: tokens,
-			IndexNameField: nameTokens,
-			IndexPkgField:  villa.NewStrSet(hit.Package),
-		}, *hit)
+		ts.AddDoc(IndexFields(hit), *hit)
 	}
 
 	DumpMemStats()
 	return ts, nil
 }
+
+// IndexFields returns the tokens of the indexed fields of a hit.
+func IndexFields(hit *HitInfo) map[string]villa.StrSet {
+	var nameTokens villa.StrSet
+	nameTokens = AppendTokens(nameTokens, []byte(hit.Name))
+
+	var tokens villa.StrSet
+	tokens.Put(nameTokens.Elements()...)
+	tokens = AppendTokens(tokens, []byte(hit.Package))
+	tokens = AppendTokens(tokens, []byte(hit.Description))
+	tokens = AppendTokens(tokens, []byte(hit.ReadmeData))
+	tokens = AppendTokens(tokens, []byte(hit.Author))
+	for _, ex := range hit.Examples {
+		tokens = AppendTokens(tokens, []byte(ex.Name))
+		tokens = AppendTokens(tokens, []byte(ex.Doc))
+	}
+
+	var symbols villa.StrSet
+	for _, sym := range hit.Exported {
+		tokens = AppendTokens(tokens, []byte(sym))
+		symbols.Put(sym)
+		if p := strings.LastIndex(sym, "."); p >= 0 {
+			// a method is also searchable by its name
+			symbols.Put(sym[p+1:])
+		}
+	}
+
+	var licenses villa.StrSet
+	if hit.License != "" {
+		licenses.Put(strings.ToLower(hit.License))
+	}
+
+	return map[string]villa.StrSet{
+		IndexTextField:    tokens,
+		IndexNameField:    nameTokens,
+		IndexPkgField:     villa.NewStrSet(hit.Package),
+		IndexSymbolField:  symbols,
+		IndexLicenseField: licenses,
+	}
+}
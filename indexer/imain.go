@@ -1,14 +1,28 @@
 package main
 
 import (
+	"flag"
 	"github.com/daviddengcn/gcse"
 	"log"
 	//	"time"
 )
 
+var onRequest = flag.Bool("requested", false,
+	"index only if a reindexing is requested at /admin of the server")
+
 func main() {
+	flag.Parse()
 	log.Println("indexer started...")
 
+	if *onRequest {
+		req := gcse.PendingReindexRequest()
+		if req == nil {
+			log.Println("No reindexing requested, indexer exits...")
+			return
+		}
+		log.Printf("Reindexing requested at %v", req.Time)
+	}
+
 	if err := gcse.IndexSegments.ClearUndones(); err != nil {
 		log.Printf("ClearUndones failed: %v", err)
 	}
@@ -16,7 +30,11 @@ func main() {
 	if err := clearOutdatedIndex(); err != nil {
 		log.Printf("clearOutdatedIndex failed: %v", err)
 	}
-	doIndex()
+	if doIndex() {
+		if err := gcse.ClearReindexRequest(); err != nil {
+			log.Printf("ClearReindexRequest failed: %v", err)
+		}
+	}
 
 	log.Println("indexer exits...")
 }
@@ -35,6 +35,18 @@ func clearOutdatedIndex() error {
 }
 
 func doIndex() bool {
+	var info gcse.IndexInfo
+	if prevSegm, err := gcse.IndexSegments.FindMaxDone(); err == nil &&
+		prevSegm != nil {
+		// IndexFn is not saved with shards
+		if st, err := prevSegm.Join(gcse.IndexFn).Stat(); err == nil {
+			info.PrevUpdated = st.ModTime()
+		} else if st, err := prevSegm.Join(
+			gcse.MMIndexShardFn(0)).Stat(); err == nil {
+			info.PrevUpdated = st.ModTime()
+		}
+	}
+
 	idxSegm, err := gcse.IndexSegments.GenMaxSegment()
 	if err != nil {
 		log.Printf("GenMaxSegment failed: %v", err)
@@ -46,38 +58,77 @@ func doIndex() bool {
 
 	log.Printf("Indexing to %v ...", idxSegm)
 
+	// group packages of custom import paths by their repositories
+	gcse.LoadVanityRepos(gcse.NewMemDB(gcse.CrawlerDBPath, gcse.KindVanity))
+	// banned packages are not indexed
+	gcse.LoadBanList(gcse.NewMemDB(gcse.CrawlerDBPath, gcse.KindBan))
+
 	fpDocDB := sophie.LocalFsPath(gcse.DocsDBPath.S())
 
-	ts, err := gcse.Index(kv.DirInput(fpDocDB))
-	if err != nil {
-		log.Printf("Indexing failed: %v", err)
-		return false
-	}
+	docCount := 0
+	if gcse.IndexShards > 1 {
+		// shards are built from the docs directly, without the whole index
+		// in memory
+		log.Printf("Saving %d mmindex shards to %v ...", gcse.IndexShards,
+			idxSegm)
+		cnt, err := gcse.SaveMMIndexShards(idxSegm, kv.DirInput(fpDocDB),
+			gcse.IndexShards)
+		if err != nil {
+			log.Printf("SaveMMIndexShards failed: %v", err)
+			return false
+		}
+		docCount = cnt
+	} else {
+		ts, err := gcse.Index(kv.DirInput(fpDocDB))
+		if err != nil {
+			log.Printf("Indexing failed: %v", err)
+			return false
+		}
 
-	f, err := idxSegm.Join(gcse.IndexFn).Create()
-	if err != nil {
-		log.Printf("Create index file failed: %v", err)
-		return false
-	}
-	//defer f.Close()
-	log.Printf("Saving index to %v ...", idxSegm)
-	if err := ts.Save(f); err != nil {
-		log.Printf("ts.Save failed: %v", err)
-		return false
+		f, err := idxSegm.Join(gcse.IndexFn).Create()
+		if err != nil {
+			log.Printf("Create index file failed: %v", err)
+			return false
+		}
+		log.Printf("Saving index to %v ...", idxSegm)
+		if err := ts.Save(f); err != nil {
+			f.Close()
+			log.Printf("ts.Save failed: %v", err)
+			return false
+		}
+		f.Close()
+		runtime.GC()
+		gcse.DumpMemStats()
+
+		f, err = idxSegm.Join(gcse.MMIndexFn).Create()
+		if err != nil {
+			log.Printf("Create mmindex file failed: %v", err)
+			return false
+		}
+		log.Printf("Saving mmindex to %v ...", idxSegm)
+		if err := gcse.SaveMMIndex(f, ts); err != nil {
+			f.Close()
+			log.Printf("SaveMMIndex failed: %v", err)
+			return false
+		}
+		f.Close()
+		docCount = ts.DocCount()
 	}
-	f.Close()
-	f = nil
 	runtime.GC()
 	gcse.DumpMemStats()
 
+	if err := gcse.WriteJsonFile(idxSegm.Join(gcse.IndexInfoFn), info); err != nil {
+		log.Printf("Saving index info failed: %v", err)
+		return false
+	}
+
 	if err := idxSegm.Done(); err != nil {
 		log.Printf("segm.Done failed: %v", err)
 		return false
 	}
 
-	log.Printf("Indexing success: %s (%d)", idxSegm, ts.DocCount())
+	log.Printf("Indexing success: %s (%d)", idxSegm, docCount)
 
-	ts = nil
 	gcse.DumpMemStats()
 	runtime.GC()
 	gcse.DumpMemStats()
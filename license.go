@@ -0,0 +1,287 @@
+package gcse
+
+import (
+	"strings"
+	"unicode"
+)
+
+// licenseTitles identifies long licenses by their titles, which are checked
+// in the beginning of a text before comparing the text with licenseTexts.
+// The titles of the GNU licenses mention each other in their texts, so only
+// the beginning is checked.
+var licenseTitles = []struct {
+	spdx  string
+	title string
+}{
+	{"AGPL-3.0", "gnu affero general public license version 3"},
+	{"LGPL-3.0", "gnu lesser general public license version 3"},
+	{"LGPL-2.1", "gnu lesser general public license version 2.1"},
+	{"LGPL-2.0", "gnu library general public license version 2"},
+	{"GPL-3.0", "gnu general public license version 3"},
+	{"GPL-2.0", "gnu general public license version 2"},
+	{"Apache-2.0", "apache license version 2.0"},
+	{"MPL-2.0", "mozilla public license version 2.0"},
+	{"EPL-1.0", "eclipse public license v 1.0"},
+	{"CC0-1.0", "cc0 1.0 universal"},
+}
+
+// number of leading words of a text searched for licenseTitles
+const licenseTitleWords = 100
+
+// licenseTexts are the texts of short licenses whose variants are only
+// distinguishable by comparing the whole texts.
+var licenseTexts = map[string]string{
+	"MIT": `Permission is hereby granted, free of charge, to any person
+obtaining a copy of this software and associated documentation files (the
+"Software"), to deal in the Software without restriction, including without
+limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom
+the Software is furnished to do so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.`,
+	"BSD-2-Clause": `Redistribution and use in source and binary forms, with or
+without modification, are permitted provided that the following conditions
+are met:
+1. Redistributions of source code must retain the above copyright notice,
+this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.`,
+	"BSD-3-Clause": `Redistribution and use in source and binary forms, with or
+without modification, are permitted provided that the following conditions
+are met:
+1. Redistributions of source code must retain the above copyright notice,
+this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its
+contributors may be used to endorse or promote products derived from this
+software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.`,
+	"ISC": `Permission to use, copy, modify, and/or distribute this software
+for any purpose with or without fee is hereby granted, provided that the above
+copyright notice and this permission notice appear in all copies.
+THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES WITH
+REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF MERCHANTABILITY
+AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT,
+INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM
+LOSS OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT, NEGLIGENCE OR
+OTHER TORTIOUS ACTION, ARISING OUT OF OR IN CONNECTION WITH THE USE OR
+PERFORMANCE OF THIS SOFTWARE.`,
+	"Unlicense": `This is free and unencumbered software released into the
+public domain.
+Anyone is free to copy, modify, publish, use, compile, sell, or distribute
+this software, either in source code form or as a compiled binary, for any
+purpose, commercial or non-commercial, and by any means.
+In jurisdictions that recognize copyright laws, the author or authors of this
+software dedicate any and all copyright interest in the software to the
+public domain. We make this dedication for the benefit of the public at large
+and to the detriment of our heirs and successors. We intend this dedication
+to be an overt act of relinquishment in perpetuity of all present and future
+rights to this software under copyright law.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN
+ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+For more information, please refer to <http://unlicense.org/>`,
+	"WTFPL": `DO WHAT THE FUCK YOU WANT TO PUBLIC LICENSE
+Version 2, December 2004
+Everyone is permitted to copy and distribute verbatim or modified copies of
+this license document, and changing it is allowed as long as the name is
+changed.
+DO WHAT THE FUCK YOU WANT TO PUBLIC LICENSE
+TERMS AND CONDITIONS FOR COPYING, DISTRIBUTION AND MODIFICATION
+0. You just DO WHAT THE FUCK YOU WANT TO.`,
+}
+
+// minimum similarity of a text to be classified as one of licenseTexts
+const licenseMinSimilarity = 0.75
+
+var licenseBigrams map[string]map[string]bool
+
+func init() {
+	licenseBigrams = make(map[string]map[string]bool)
+	for spdx, text := range licenseTexts {
+		licenseBigrams[spdx] = wordBigrams(text)
+	}
+}
+
+// licenseWords returns the lower-cased words of a text. Dots are kept for
+// version numbers like 2.0.
+func licenseWords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.'
+	})
+	res := words[:0]
+	for _, w := range words {
+		if w = strings.Trim(w, "."); w != "" {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+func wordBigrams(text string) map[string]bool {
+	words := licenseWords(text)
+	bigrams := make(map[string]bool, len(words))
+	for i := 1; i < len(words); i++ {
+		bigrams[words[i-1]+" "+words[i]] = true
+	}
+	return bigrams
+}
+
+// diceSimilarity returns the Sørensen–Dice coefficient of two sets.
+func diceSimilarity(a, b map[string]bool) float64 {
+	if len(a)+len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for k := range a {
+		if b[k] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(a)+len(b))
+}
+
+// DetectLicense classifies the text of a LICENSE/COPYING file. Returns the
+// SPDX identifier of the license, or "" if not recognized.
+func DetectLicense(text string) string {
+	words := licenseWords(text)
+	if len(words) > licenseTitleWords {
+		words = words[:licenseTitleWords]
+	}
+	head := " " + strings.Join(words, " ") + " "
+	for _, lt := range licenseTitles {
+		if strings.Contains(head, " "+lt.title+" ") {
+			return lt.spdx
+		}
+	}
+
+	bigrams := wordBigrams(text)
+	best, bestSim := "", 0.
+	for spdx, lb := range licenseBigrams {
+		sim := diceSimilarity(bigrams, lb)
+		if sim >= licenseMinSimilarity && sim > bestSim {
+			best, bestSim = spdx, sim
+		}
+	}
+	return best
+}
+
+// IsLicenseFile returns whether a file name is of a license file, e.g.
+// LICENSE, LICENSE.txt or COPYING.
+func IsLicenseFile(fn string) bool {
+	fn = strings.ToUpper(fn)
+	if p := strings.Index(fn, "."); p >= 0 {
+		fn = fn[:p]
+	}
+	switch fn {
+	case "LICENSE", "LICENCE", "COPYING", "UNLICENSE", "LICENSE-MIT":
+		return true
+	}
+	return false
+}
+
+// licenseNames identifies licenses by their short names mentioned in the
+// license section of a README, e.g. "Released under the MIT License.", in
+// words of licenseWords.
+var licenseNames = []struct {
+	spdx string
+	name string
+}{
+	{"MIT", "mit license"},
+	{"Apache-2.0", "apache license 2.0"},
+	{"BSD-3-Clause", "bsd 3 clause"},
+	{"BSD-2-Clause", "bsd 2 clause"},
+	{"ISC", "isc license"},
+	{"MPL-2.0", "mpl 2.0"},
+}
+
+// readmeLicenseSection returns the text of the section of a README titled
+// License or Licence, in Markdown or plain text, "" if not found.
+func readmeLicenseSection(readme string) string {
+	lines := strings.Split(readme, "\n")
+	isHeading := func(i int) bool {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "#") {
+			return true
+		}
+		// a setext heading
+		next := ""
+		if i+1 < len(lines) {
+			next = strings.TrimSpace(lines[i+1])
+		}
+		return line != "" && next != "" && strings.Trim(next, "=-") == ""
+	}
+	for i := range lines {
+		if !isHeading(i) {
+			continue
+		}
+		title := strings.ToLower(strings.Trim(lines[i], "# \t\r"))
+		if title != "license" && title != "licence" {
+			continue
+		}
+		start := i + 1
+		if !strings.HasPrefix(strings.TrimSpace(lines[i]), "#") {
+			start++
+		}
+		end := start
+		for end < len(lines) && !isHeading(end) {
+			end++
+		}
+		return strings.Join(lines[start:end], "\n")
+	}
+	return ""
+}
+
+// ReadmeLicense detects the license in the License section of a README, for
+// packages whose license files are not fetched, e.g. not on GitHub. Returns
+// the SPDX identifier or "" if not recognized.
+func ReadmeLicense(readme string) string {
+	section := readmeLicenseSection(readme)
+	if section == "" {
+		return ""
+	}
+	if license := DetectLicense(section); license != "" {
+		return license
+	}
+	words := " " + strings.Join(licenseWords(section), " ") + " "
+	for _, ln := range licenseNames {
+		if strings.Contains(words, " "+ln.name+" ") {
+			return ln.spdx
+		}
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package gcse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daviddengcn/go-assert"
+)
+
+func TestDetectLicense(t *testing.T) {
+	mit := "The MIT License (MIT)\n\nCopyright (c) 2014 David Deng\n\n" +
+		licenseTexts["MIT"]
+	assert.Equals(t, "MIT", DetectLicense(mit), "MIT")
+
+	bsd3 := "Copyright (c) 2013, Foo Bar. All rights reserved.\n\n" +
+		licenseTexts["BSD-3-Clause"]
+	assert.Equals(t, "BSD-3", DetectLicense(bsd3), "BSD-3-Clause")
+	bsd2 := "Copyright (c) 2013, Foo Bar. All rights reserved.\n\n" +
+		licenseTexts["BSD-2-Clause"]
+	assert.Equals(t, "BSD-2", DetectLicense(bsd2), "BSD-2-Clause")
+
+	apache := `
+                                 Apache License
+                           Version 2.0, January 2004
+                        http://www.apache.org/licenses/
+
+   TERMS AND CONDITIONS FOR USE, REPRODUCTION, AND DISTRIBUTION`
+	assert.Equals(t, "Apache", DetectLicense(apache), "Apache-2.0")
+
+	gpl3 := `                    GNU GENERAL PUBLIC LICENSE
+                       Version 3, 29 June 2007
+` + strings.Repeat("blah ", 200) + `
+  13. Use with the GNU Affero General Public License, version 3.`
+	assert.Equals(t, "GPL-3.0", DetectLicense(gpl3), "GPL-3.0")
+
+	assert.Equals(t, "unknown", DetectLicense(
+		"All rights reserved. Do not copy."), "")
+}
+
+func TestIsLicenseFile(t *testing.T) {
+	for _, fn := range []string{"LICENSE", "license.txt", "COPYING", "LICENSE.md",
+		"UNLICENSE", "Licence"} {
+		assert.IsTrue(t, fn, IsLicenseFile(fn))
+	}
+	for _, fn := range []string{"README.md", "main.go", "LICENSES"} {
+		assert.IsFalse(t, fn, IsLicenseFile(fn))
+	}
+}
+
+func TestReadmeLicense(t *testing.T) {
+	assert.Equals(t, "markdown", ReadmeLicense(`# pkg
+A package.
+
+## License
+
+Released under the [MIT License](LICENSE).
+
+## Authors
+Apache License 2.0 is not the license.
+`), "MIT")
+	assert.Equals(t, "setext", ReadmeLicense(`pkg
+===
+Licence
+-------
+Licensed under the Apache License, Version 2.0.
+`), "Apache-2.0")
+	assert.Equals(t, "full text", ReadmeLicense("### LICENSE\n"+
+		licenseTexts["BSD-3-Clause"]), "BSD-3-Clause")
+	assert.Equals(t, "no section", ReadmeLicense(
+		"# pkg\nUnder the MIT License."), "")
+}
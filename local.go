@@ -0,0 +1,502 @@
+package gcse
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"go/ast"
+	godoc "go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/daviddengcn/gddo/doc"
+	"github.com/daviddengcn/go-villa"
+)
+
+// localTree is a tree of files, either a directory of the file system or the
+// HEAD of a bare git repository. Directories are slash-separated paths
+// relative to the root, "" for the root.
+type localTree interface {
+	// Files returns the names of the regular files in a directory.
+	Files(dir string) ([]string, error)
+	ReadFile(dir, name string) ([]byte, error)
+}
+
+type fsTree string
+
+func (t fsTree) Files(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(string(t),
+		filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+func (t fsTree) ReadFile(dir, name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(t), filepath.FromSlash(dir),
+		name))
+}
+
+// gitTree is the HEAD of a bare git repository, read by the git command.
+type gitTree string
+
+func (t gitTree) git(args ...string) ([]byte, error) {
+	out, err := exec.Command("git", append([]string{"--git-dir=" +
+		string(t)}, args...)...).Output()
+	if err != nil {
+		return nil, villa.NestErrorf(err, "git %s in %s",
+			strings.Join(args, " "), t)
+	}
+	return out, nil
+}
+
+// splitNUL splits the output of a git command with -z into entries.
+func splitNUL(out []byte) []string {
+	return strings.FieldsFunc(string(out), func(r rune) bool {
+		return r == 0
+	})
+}
+
+func (t gitTree) Files(dir string) ([]string, error) {
+	// -z: entries are NUL terminated and names are not quoted
+	args := []string{"ls-tree", "-z", "HEAD"}
+	if dir != "" {
+		args = append(args, dir+"/")
+	}
+	out, err := t.git(args...)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range splitNUL(out) {
+		// <mode> SP <type> SP <object> TAB <file>
+		tab := strings.Index(line, "\t")
+		if tab < 0 {
+			continue
+		}
+		if fields := strings.Fields(line[:tab]); len(fields) != 3 ||
+			fields[1] != "blob" {
+			continue
+		}
+		names = append(names, path.Base(line[tab+1:]))
+	}
+	return names, nil
+}
+
+func (t gitTree) ReadFile(dir, name string) ([]byte, error) {
+	return t.git("cat-file", "blob", "HEAD:"+path.Join(dir, name))
+}
+
+// Dirs returns all directories of the tree.
+func (t gitTree) Dirs() ([]string, error) {
+	out, err := t.git("ls-tree", "-r", "-d", "-z", "--name-only", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{""}, splitNUL(out)...), nil
+}
+
+// isBareRepo returns whether a directory is a bare git repository.
+func isBareRepo(dir string) bool {
+	for _, fn := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, fn)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ignoredDir returns whether a directory is ignored by the go tool.
+func ignoredDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") ||
+		name == "testdata"
+}
+
+// localRootOf returns the import prefix and the directory of the
+// CrawlerLocalRoots entry of a package. Returns "", "" if not found.
+func localRootOf(pkg string) (prefix string, root villa.Path) {
+	for p, r := range CrawlerLocalRoots {
+		if hasPathPrefix(pkg, p) && len(p) > len(prefix) {
+			prefix, root = p, r
+		}
+	}
+	return prefix, root
+}
+
+// IsLocalPackage returns whether a package is in CrawlerLocalRoots.
+func IsLocalPackage(pkg string) bool {
+	prefix, _ := localRootOf(pkg)
+	return prefix != ""
+}
+
+// localTreeOf returns the localTree of a package in CrawlerLocalRoots and the
+// directory of the package in it. A bare git repository named <name>.git or
+// <name> is used as directory <name>.
+func localTreeOf(pkg string) (localTree, string) {
+	prefix, root := localRootOf(pkg)
+	if prefix == "" {
+		return nil, ""
+	}
+	cur := root.S()
+	if isBareRepo(cur) {
+		return gitTree(cur), strings.TrimPrefix(pkg[len(prefix):], "/")
+	}
+	parts := strings.Split(strings.TrimPrefix(pkg[len(prefix):], "/"), "/")
+	for i, part := range parts {
+		if part == "" {
+			break
+		}
+		for _, dir := range []string{filepath.Join(cur, part+".git"),
+			filepath.Join(cur, part)} {
+			if isBareRepo(dir) {
+				return gitTree(dir), strings.Join(parts[i+1:], "/")
+			}
+		}
+		cur = filepath.Join(cur, part)
+	}
+	return fsTree(root.S()), strings.TrimPrefix(pkg[len(prefix):], "/")
+}
+
+func isGoFile(name string) bool {
+	return strings.HasSuffix(name, ".go") && !ignoredDir(name)
+}
+
+func hasGoFiles(tree localTree, dir string) bool {
+	names, err := tree.Files(dir)
+	if err != nil {
+		return false
+	}
+	for _, name := range names {
+		if isGoFile(name) && !strings.HasSuffix(name, "_test.go") {
+			return true
+		}
+	}
+	return false
+}
+
+// LocalPackages returns the import paths of all packages in
+// CrawlerLocalRoots, including the ones in bare git repositories.
+func LocalPackages() ([]string, error) {
+	var pkgs []string
+	for prefix, root := range CrawlerLocalRoots {
+		if err := filepath.Walk(root.S(), func(fn string, info os.FileInfo,
+			err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(root.S(), fn)
+			if err != nil {
+				return err
+			}
+			pkg := prefix
+			if rel = filepath.ToSlash(rel); rel != "." {
+				if ignoredDir(info.Name()) {
+					return filepath.SkipDir
+				}
+				pkg += "/" + rel
+			}
+			if !isBareRepo(fn) {
+				if hasGoFiles(fsTree(fn), "") {
+					pkgs = append(pkgs, pkg)
+				}
+				return nil
+			}
+			pkg = strings.TrimSuffix(pkg, ".git")
+			repo := gitTree(fn)
+			dirs, err := repo.Dirs()
+			if err != nil {
+				return err
+			}
+			for _, dir := range dirs {
+				if ignoredDir(path.Base(dir)) {
+					continue
+				}
+				if hasGoFiles(repo, dir) {
+					pkgs = append(pkgs, strings.TrimSuffix(pkg+"/"+dir, "/"))
+				}
+			}
+			return filepath.SkipDir
+		}); err != nil {
+			return nil, villa.NestErrorf(err, "LocalPackages(%s)", root)
+		}
+	}
+	return pkgs, nil
+}
+
+// isIgnoredGoFile returns whether a Go file is excluded by the ignore build
+// tag.
+func isIgnoredGoFile(f *ast.File) bool {
+	for _, cg := range f.Comments {
+		if cg.Pos() >= f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if fields := strings.Fields(strings.TrimPrefix(c.Text,
+				"//")); len(fields) > 1 && (fields[0] == "+build" ||
+				fields[0] == "go:build") {
+				for _, tag := range fields[1:] {
+					if tag == "ignore" {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+func importsOfFiles(files []*ast.File) []string {
+	var imports villa.StrSet
+	for _, f := range files {
+		for _, imp := range f.Imports {
+			if p, err := strconv.Unquote(imp.Path.Value); err == nil {
+				imports.Put(p)
+			}
+		}
+	}
+	return imports.Elements()
+}
+
+// exportedOfDoc returns the exported symbols of a go/doc Package in the form
+// of exportedSymbols.
+func exportedOfDoc(dpkg *godoc.Package) []string {
+	var exported villa.StrSet
+	putValues := func(values []*godoc.Value) {
+		for _, v := range values {
+			for _, name := range v.Names {
+				if ast.IsExported(name) {
+					exported.Put(name)
+				}
+			}
+		}
+	}
+	putValues(dpkg.Consts)
+	putValues(dpkg.Vars)
+	for _, f := range dpkg.Funcs {
+		exported.Put(f.Name)
+	}
+	for _, t := range dpkg.Types {
+		exported.Put(t.Name)
+		putValues(t.Consts)
+		putValues(t.Vars)
+		for _, f := range t.Funcs {
+			exported.Put(f.Name)
+		}
+		for _, m := range t.Methods {
+			exported.Put(t.Name + "." + m.Name)
+		}
+	}
+	return exported.Elements()
+}
+
+// exampleCode renders the body of an example without the braces.
+func exampleCode(fset *token.FileSet, e *godoc.Example) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, &printer.CommentedNode{
+		Node:     e.Code,
+		Comments: e.Comments,
+	})
+	code := buf.String()
+	if _, ok := e.Code.(*ast.BlockStmt); !ok {
+		return code
+	}
+	code = strings.TrimSuffix(strings.TrimPrefix(code, "{"), "}")
+	lines := strings.Split(strings.Trim(code, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, "\t")
+	}
+	if e.Output != "" || e.EmptyOutput {
+		// the output comment is shown by Example.Source
+		for i := len(lines) - 1; i >= 0; i-- {
+			l := strings.ToLower(lines[i])
+			if strings.HasPrefix(l, "// output:") ||
+				strings.HasPrefix(l, "// unordered output:") {
+				lines = lines[:i]
+				break
+			}
+		}
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+// localExamples returns the examples in test files, at most maxExamples of
+// them as packageExamples.
+func localExamples(fset *token.FileSet, files []*ast.File) []Example {
+	var examples []Example
+	for _, e := range godoc.Examples(files...) {
+		if len(examples) >= maxExamples {
+			break
+		}
+		code := exampleCode(fset, e)
+		if len(code) > maxExampleBytes {
+			continue
+		}
+		examples = append(examples, Example{
+			Name:   e.Name,
+			Doc:    e.Doc,
+			Code:   code,
+			Output: e.Output,
+		})
+	}
+	return examples
+}
+
+// localLicense detects the license in the directory of a package or its
+// parent directories in the tree.
+func localLicense(tree localTree, dir string) string {
+	for {
+		names, _ := tree.Files(dir)
+		for _, name := range names {
+			if !IsLicenseFile(name) {
+				continue
+			}
+			if data, err := tree.ReadFile(dir, name); err == nil {
+				if len(data) > maxLicenseBytes {
+					data = data[:maxLicenseBytes]
+				}
+				if license := DetectLicense(string(data)); license != "" {
+					return license
+				}
+			}
+		}
+		if dir == "" {
+			return ""
+		}
+		if dir = path.Dir(dir); dir == "." {
+			dir = ""
+		}
+	}
+}
+
+// CrawlLocalPackage parses a package in CrawlerLocalRoots into a Package
+// without network access. The Etag is the MD5 of the Go files and the README
+// of the package.
+func CrawlLocalPackage(pkg string, etag string) (*Package, error) {
+	tree, dir := localTreeOf(pkg)
+	if tree == nil {
+		return nil, doc.NotFoundError{Message: pkg + " is not local"}
+	}
+	names, err := tree.Files(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, doc.NotFoundError{Message: err.Error()}
+		}
+		return nil, villa.NestErrorf(err, "CrawlLocalPackage(%s)", pkg)
+	}
+
+	h := md5.New()
+	fset := token.NewFileSet()
+	var files, testFiles []*ast.File
+	readmeFn, readmeData := "", ""
+	for _, name := range names {
+		isReadme := strings.HasPrefix(strings.ToLower(name), "readme")
+		if !isGoFile(name) && !(isReadme && readmeFn == "") {
+			continue
+		}
+		data, err := tree.ReadFile(dir, name)
+		if err != nil {
+			return nil, villa.NestErrorf(err, "CrawlLocalPackage(%s)", pkg)
+		}
+		fmt.Fprintf(h, "%s %d\n", name, len(data))
+		h.Write(data)
+		if !isGoFile(name) {
+			if data := strings.TrimSpace(string(data)); len(data) > 1 &&
+				utf8.ValidString(data) {
+				readmeFn, readmeData = name, data
+			}
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, data, parser.ParseComments)
+		if err != nil {
+			log.Printf("Parsing %s of %s failed: %v", name, pkg, err)
+			continue
+		}
+		if isIgnoredGoFile(f) {
+			continue
+		}
+		if strings.HasSuffix(name, "_test.go") {
+			testFiles = append(testFiles, f)
+		} else {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil, doc.NotFoundError{Message: "no Go files in " + pkg}
+	}
+	newEtag := fmt.Sprintf("%x", h.Sum(nil))
+	if newEtag == etag {
+		return nil, ErrPackageNotModifed
+	}
+
+	// the most common package name
+	nameCnt := make(map[string]int)
+	name := ""
+	for _, f := range files {
+		n := f.Name.Name
+		if nameCnt[n]++; nameCnt[n] > nameCnt[name] {
+			name = n
+		}
+	}
+	astPkg := &ast.Package{
+		Name:  name,
+		Files: make(map[string]*ast.File),
+	}
+	for _, f := range files {
+		if f.Name.Name == name {
+			astPkg.Files[fset.Position(f.Package).Filename] = f
+		}
+	}
+
+	imports := importsOfFiles(files)
+	testImports := villa.NewStrSet(importsOfFiles(testFiles)...)
+	testImports.Delete(imports...)
+	testImports.Delete(pkg)
+	examples := localExamples(fset, testFiles)
+
+	dpkg := godoc.New(astPkg, pkg, 0)
+	synopsis := godoc.Synopsis(dpkg.Doc)
+	if synopsis == "" {
+		synopsis = godoc.Synopsis(ReadmeToText(readmeFn, readmeData))
+	}
+	if len(readmeData) > 100*1024 {
+		readmeData = readmeData[:100*1024]
+	}
+
+	return &Package{
+		Package:  pkg,
+		Name:     name,
+		Synopsis: synopsis,
+		Doc:      dpkg.Doc,
+
+		ReadmeFn:   readmeFn,
+		ReadmeData: readmeData,
+
+		Imports:     imports,
+		TestImports: testImports.Elements(),
+		Exported:    exportedOfDoc(dpkg),
+		Examples:    examples,
+		License:     localLicense(tree, dir),
+
+		Etag: newEtag,
+	}, nil
+}
@@ -0,0 +1,155 @@
+package gcse
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-villa"
+)
+
+var localFiles = map[string]string{
+	"a/a.go": `// Package a is a local package.
+package a
+
+import "example.com/corp/b"
+
+const Max = 10
+
+type T struct{}
+
+func (T) M() {}
+
+func New() T { return T{} }
+
+var _ = b.X
+`,
+	"a/a_test.go": `package a
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestA(t *testing.T) {}
+
+func ExampleNew() {
+	fmt.Println(New())
+	// Output: {}
+}
+`,
+	"a/README.md":             "# a\nThe a package.",
+	"b/b.go":                  "package b\n\nvar X = 1\n",
+	"b/gen.go":                "// +build ignore\n\npackage main\n",
+	"LICENSE":                 licenseTexts["MIT"],
+	"testdata/c.go":           "package c\n",
+	"doc/my notes/a note.txt": "not a package\n",
+}
+
+func writeLocalFiles(t *testing.T, root string) {
+	for fn, data := range localFiles {
+		fn = filepath.Join(root, filepath.FromSlash(fn))
+		os.MkdirAll(filepath.Dir(fn), 0755)
+		if err := ioutil.WriteFile(fn, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+}
+
+func checkLocalPackages(t *testing.T, prefix string) {
+	pkgs, err := LocalPackages()
+	assert.NoErrorf(t, "LocalPackages: %v", err)
+	assert.StringEquals(t, "pkgs", villa.NewStrSet(pkgs...).Elements(),
+		[]string{prefix + "/a", prefix + "/b"})
+
+	p, err := CrawlLocalPackage(prefix+"/a", "")
+	assert.NoErrorf(t, "CrawlLocalPackage: %v", err)
+	assert.Equals(t, "Name", p.Name, "a")
+	assert.Equals(t, "Synopsis", p.Synopsis, "Package a is a local package.")
+	assert.StringEquals(t, "Imports", p.Imports, []string{"example.com/corp/b"})
+	assert.StringEquals(t, "TestImports", p.TestImports,
+		[]string{"fmt", "testing"})
+	assert.StringEquals(t, "Exported", p.Exported,
+		[]string{"Max", "New", "T", "T.M"})
+	assert.Equals(t, "len(Examples)", len(p.Examples), 1)
+	assert.Equals(t, "Examples[0].Code", p.Examples[0].Code,
+		"fmt.Println(New())")
+	assert.Equals(t, "Examples[0].Output", p.Examples[0].Output, "{}\n")
+	assert.Equals(t, "ReadmeFn", p.ReadmeFn, "README.md")
+	assert.Equals(t, "License", p.License, "MIT")
+
+	_, err = CrawlLocalPackage(prefix+"/a", p.Etag)
+	assert.Equals(t, "err", err, ErrPackageNotModifed)
+
+	p, err = CrawlLocalPackage(prefix+"/b", "")
+	assert.NoErrorf(t, "CrawlLocalPackage: %v", err)
+	assert.Equals(t, "Name", p.Name, "b")
+
+	_, err = CrawlLocalPackage(prefix+"/none", "")
+	assert.IsTrue(t, "IsBadPackage", IsBadPackage(err))
+}
+
+func TestCrawlLocalPackage(t *testing.T) {
+	root, err := ioutil.TempDir("", "gcse-local")
+	assert.NoErrorf(t, "TempDir: %v", err)
+	defer os.RemoveAll(root)
+	writeLocalFiles(t, root)
+
+	defer func(roots map[string]villa.Path) {
+		CrawlerLocalRoots = roots
+	}(CrawlerLocalRoots)
+	CrawlerLocalRoots = map[string]villa.Path{
+		"example.com/corp": villa.Path(root),
+	}
+	assert.IsTrue(t, "IsLocalPackage", IsLocalPackage("example.com/corp/a"))
+	assert.IsFalse(t, "IsLocalPackage", IsLocalPackage("example.com/corpx"))
+
+	checkLocalPackages(t, "example.com/corp")
+}
+
+func TestCrawlLocalPackage_Git(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	root, err := ioutil.TempDir("", "gcse-local")
+	assert.NoErrorf(t, "TempDir: %v", err)
+	defer os.RemoveAll(root)
+
+	work := filepath.Join(root, "work")
+	writeLocalFiles(t, work)
+	git := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	git(work, "init", "-q")
+	git(work, "add", ".")
+	git(work, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-qm",
+		"init")
+	mirror := filepath.Join(root, "mirror")
+	os.MkdirAll(mirror, 0755)
+	git(root, "clone", "-q", "--bare", work, filepath.Join(mirror, "r.git"))
+
+	defer func(roots map[string]villa.Path) {
+		CrawlerLocalRoots = roots
+	}(CrawlerLocalRoots)
+	CrawlerLocalRoots = map[string]villa.Path{
+		"example.com/m": villa.Path(mirror),
+	}
+	checkLocalPackages(t, "example.com/m/r")
+
+	// names with spaces
+	tree := gitTree(filepath.Join(mirror, "r.git"))
+	dirs, err := tree.Dirs()
+	assert.NoErrorf(t, "Dirs: %v", err)
+	assert.StringEquals(t, "dirs", villa.NewStrSet(dirs...).Elements(),
+		[]string{"", "a", "b", "doc", "doc/my notes", "testdata"})
+	files, err := tree.Files("doc/my notes")
+	assert.NoErrorf(t, "Files: %v", err)
+	assert.StringEquals(t, "files", files, []string{"a note.txt"})
+}
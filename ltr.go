@@ -0,0 +1,320 @@
+package gcse
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/daviddengcn/go-villa"
+)
+
+const (
+	// the score of a hit is StaticScore * MatchScore
+	ScorerDefault = "default"
+	// the score of a hit is computed by the RankModel in ServerRankModel
+	ScorerModel = "model"
+)
+
+// Indexes of the features of a hit returned by RankFeatures.
+const (
+	RankLogStatic = iota
+	RankLogMatch
+	RankSynopsisMatch
+	RankSentencesMatch
+	RankNameMatch
+	RankPackageMatch
+	RankLogStars
+	RankLogImported
+	RankLogTestImported
+
+	RankFeatureCount
+)
+
+// RankFeatureNames are the names of the features returned by RankFeatures.
+var RankFeatureNames = []string{
+	"log_static", "log_match", "synopsis_match", "sentences_match",
+	"name_match", "package_match", "log_stars", "log_imported",
+	"log_test_imported",
+}
+
+// RankFeatures returns the features of a hit for ranking by a RankModel.
+func RankFeatures(hit *HitInfo, match MatchComponents,
+	matchScore float64) []float64 {
+	static := math.Max(hit.StaticScore, hit.TestStaticScore)
+	stars := hit.StarCount
+	if stars < 0 {
+		stars = 0
+	}
+	f := make([]float64, RankFeatureCount)
+	f[RankLogStatic] = math.Log(math.Max(static, 1e-6))
+	f[RankLogMatch] = math.Log(math.Max(matchScore, 1e-6))
+	f[RankSynopsisMatch] = match.Synopsis
+	f[RankSentencesMatch] = match.Sentences
+	f[RankNameMatch] = match.Name
+	f[RankPackageMatch] = match.Package
+	f[RankLogStars] = math.Log1p(float64(stars))
+	f[RankLogImported] = math.Log1p(float64(len(hit.Imported)))
+	f[RankLogTestImported] = math.Log1p(float64(len(hit.TestImported)))
+	return f
+}
+
+// RankModel is a linear model scoring hits by their RankFeatures, trained
+// from the clicks in the query log by TrainRankModel.
+type RankModel struct {
+	// RankFeatureNames when trained
+	Features []string
+	// features are standardized by Mean and Std before weighted
+	Mean    []float64
+	Std     []float64
+	Weights []float64
+	Bias    float64
+
+	Trained time.Time
+	// number of preference pairs trained from
+	Pairs int
+}
+
+// NewRankModel returns a RankModel ranking hits as the default scorer, i.e.
+// by StaticScore * MatchScore, with features standardized by mean and std.
+func NewRankModel(mean, std []float64) *RankModel {
+	m := &RankModel{
+		Features: append([]string(nil), RankFeatureNames...),
+		Mean:     mean,
+		Std:      std,
+		Weights:  make([]float64, RankFeatureCount),
+	}
+	// log(static) + log(match)
+	for _, i := range []int{RankLogStatic, RankLogMatch} {
+		m.Weights[i] = std[i]
+		m.Bias += mean[i]
+	}
+	return m
+}
+
+func (m *RankModel) linear(features []float64) float64 {
+	s := m.Bias
+	for i, w := range m.Weights {
+		s += w * (features[i] - m.Mean[i]) / m.Std[i]
+	}
+	return s
+}
+
+// Score returns the score of a hit by its RankFeatures. It is exp of a
+// linear function of the features so that it is comparable with the score of
+// the default scorer.
+func (m *RankModel) Score(features []float64) float64 {
+	return math.Exp(m.linear(features))
+}
+
+// Check returns an error if the model does not fit the current RankFeatures.
+func (m *RankModel) Check() error {
+	if len(m.Features) != RankFeatureCount || len(m.Mean) != RankFeatureCount ||
+		len(m.Std) != RankFeatureCount || len(m.Weights) != RankFeatureCount {
+		return fmt.Errorf("expected %d features, got %d", RankFeatureCount,
+			len(m.Features))
+	}
+	for i, name := range m.Features {
+		if name != RankFeatureNames[i] {
+			return fmt.Errorf("feature %d: expected %s, got %s", i,
+				RankFeatureNames[i], name)
+		}
+		if m.Std[i] <= 0 {
+			return fmt.Errorf("non-positive std of feature %s", name)
+		}
+	}
+	return nil
+}
+
+// LoadRankModel loads a RankModel saved by SaveRankModel.
+func LoadRankModel(fn villa.Path) (*RankModel, error) {
+	var m RankModel
+	if err := ReadJsonFile(fn, &m); err != nil {
+		return nil, err
+	}
+	if err := m.Check(); err != nil {
+		return nil, villa.NestErrorf(err, "LoadRankModel(%v)", fn)
+	}
+	return &m, nil
+}
+
+// SaveRankModel saves a RankModel into a file.
+func SaveRankModel(fn villa.Path, m *RankModel) error {
+	return WriteJsonFile(fn, m)
+}
+
+// RankPair is a pair of hits of a search where Better is preferred to Worse,
+// each as its RankFeatures.
+type RankPair struct {
+	Better, Worse []float64
+}
+
+// RankPairCollector collects RankPairs from the query log. A clicked result
+// is preferred to the results not clicked above it, and the one next to it.
+// The pairs of a search are emitted when all its clicks are known, i.e. when
+// it is superseded by a new search of the same query and page, or by Finish.
+type RankPairCollector struct {
+	Pairs []RankPair
+
+	// the last search of a query and page, key: normalized query and page
+	searches map[string]*QueryLogEntry
+	// positions clicked of the searches
+	clicked map[*QueryLogEntry]map[int]bool
+}
+
+func NewRankPairCollector() *RankPairCollector {
+	return &RankPairCollector{
+		searches: make(map[string]*QueryLogEntry),
+		clicked:  make(map[*QueryLogEntry]map[int]bool),
+	}
+}
+
+func searchKey(q string, page int) string {
+	return fmt.Sprintf("%d\x00%s", page, normQuery(q))
+}
+
+// emit appends the pairs of the clicks of a search and forgets the clicks.
+func (c *RankPairCollector) emit(s *QueryLogEntry) {
+	clicked := c.clicked[s]
+	delete(c.clicked, s)
+	for idx := range s.Results {
+		if !clicked[idx] {
+			continue
+		}
+		better := s.Results[idx].Features
+		if len(better) != RankFeatureCount {
+			continue
+		}
+		for i := 0; i <= idx+1 && i < len(s.Results); i++ {
+			worse := s.Results[i].Features
+			if clicked[i] || len(worse) != RankFeatureCount {
+				continue
+			}
+			c.Pairs = append(c.Pairs, RankPair{Better: better, Worse: worse})
+		}
+	}
+}
+
+// Add adds an entry of the query log, in the order of the log.
+func (c *RankPairCollector) Add(ent *QueryLogEntry) {
+	switch ent.Type {
+	case QueryLogSearch:
+		if len(ent.Results) > 0 {
+			key := searchKey(ent.Query, ent.Page)
+			if s := c.searches[key]; s != nil {
+				c.emit(s)
+			}
+			e := *ent
+			c.searches[key] = &e
+		}
+	case QueryLogClick:
+		s := c.searches[searchKey(ent.Query, ent.Page)]
+		if s == nil {
+			return
+		}
+		idx := ent.Position - s.First
+		if idx < 0 || idx >= len(s.Results) ||
+			s.Results[idx].Package != ent.Package {
+			// not a result of the search, e.g. a forged or stale click
+			return
+		}
+		clicked := c.clicked[s]
+		if clicked == nil {
+			clicked = make(map[int]bool)
+			c.clicked[s] = clicked
+		}
+		clicked[idx] = true
+	}
+}
+
+// Finish emits the pairs of the searches not yet superseded. It is called
+// after all entries are added.
+func (c *RankPairCollector) Finish() {
+	keys := make([]string, 0, len(c.searches))
+	for key := range c.searches {
+		keys = append(keys, key)
+	}
+	// in a stable order
+	villa.SortF(len(keys), func(i, j int) bool {
+		return keys[i] < keys[j]
+	}, func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+	for _, key := range keys {
+		c.emit(c.searches[key])
+		delete(c.searches, key)
+	}
+}
+
+// featureStats returns the mean and the standard deviation of each feature
+// in the pairs. A zero deviation is returned as 1.
+func featureStats(pairs []RankPair) (mean, std []float64) {
+	mean, std = make([]float64, RankFeatureCount), make([]float64,
+		RankFeatureCount)
+	n := float64(2 * len(pairs))
+	for _, p := range pairs {
+		for i := range mean {
+			mean[i] += (p.Better[i] + p.Worse[i]) / n
+		}
+	}
+	for _, p := range pairs {
+		for i := range std {
+			db, dw := p.Better[i]-mean[i], p.Worse[i]-mean[i]
+			std[i] += (db*db + dw*dw) / n
+		}
+	}
+	for i := range std {
+		if std[i] = math.Sqrt(std[i]); std[i] < 1e-9 {
+			std[i] = 1
+		}
+	}
+	return mean, std
+}
+
+// RankTrainOptions are the options of TrainRankModel.
+type RankTrainOptions struct {
+	Epochs int
+	// learning rate
+	Rate float64
+	// strength of the L2 regularization towards the default scorer
+	L2 float64
+}
+
+// TrainRankModel fits a RankModel to the pairs by minimizing the pairwise
+// logistic loss with the gradient descent, starting from the default scorer.
+// Returns nil if no pairs.
+func TrainRankModel(pairs []RankPair, opts RankTrainOptions) *RankModel {
+	if len(pairs) == 0 {
+		return nil
+	}
+	mean, std := featureStats(pairs)
+	m := NewRankModel(mean, std)
+	prior := append([]float64(nil), m.Weights...)
+
+	n := float64(len(pairs))
+	grad := make([]float64, RankFeatureCount)
+	for epoch := 0; epoch < opts.Epochs; epoch++ {
+		for i := range grad {
+			grad[i] = opts.L2 * (m.Weights[i] - prior[i])
+		}
+		loss := 0.
+		for _, p := range pairs {
+			d := m.linear(p.Better) - m.linear(p.Worse)
+			// loss = log(1 + exp(-d)), dloss/dd = -1 / (1 + exp(d))
+			loss += math.Log1p(math.Exp(-d))
+			g := -1 / (1 + math.Exp(d))
+			for i := range grad {
+				grad[i] += g * (p.Better[i] - p.Worse[i]) / std[i] / n
+			}
+		}
+		for i := range grad {
+			m.Weights[i] -= opts.Rate * grad[i]
+		}
+		if epoch%100 == 0 || epoch == opts.Epochs-1 {
+			log.Printf("Epoch %d, loss %.6f", epoch, loss/n)
+		}
+	}
+	m.Trained = time.Now()
+	m.Pairs = len(pairs)
+	return m
+}
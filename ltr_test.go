@@ -0,0 +1,105 @@
+package gcse
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/daviddengcn/go-assert"
+)
+
+func TestRankModelDefault(t *testing.T) {
+	hit := &HitInfo{
+		DocInfo:         DocInfo{StarCount: 10},
+		StaticScore:     3,
+		TestStaticScore: 2,
+	}
+	match := MatchComponents{Name: 1.5}
+	features := RankFeatures(hit, match, MatchScoreOf(match, 1))
+
+	mean := make([]float64, RankFeatureCount)
+	std := make([]float64, RankFeatureCount)
+	for i := range std {
+		mean[i], std[i] = 0.5, 2
+	}
+	m := NewRankModel(mean, std)
+	assert.NoErrorf(t, "Check: %v", m.Check())
+	assert.IsTrue(t, "default score",
+		math.Abs(m.Score(features)-3*MatchScoreOf(match, 1)) < 1e-9)
+}
+
+func rankResults(features ...[]float64) []QueryLogResult {
+	var res []QueryLogResult
+	for i, f := range features {
+		res = append(res, QueryLogResult{
+			Package:  fmt.Sprintf("github.com/a/p%d", i+1),
+			Features: f,
+		})
+	}
+	return res
+}
+
+func TestRankPairCollector(t *testing.T) {
+	f := func(v float64) []float64 {
+		l := make([]float64, RankFeatureCount)
+		l[RankNameMatch] = v
+		return l
+	}
+	c := NewRankPairCollector()
+	c.Add(&QueryLogEntry{Type: QueryLogSearch, Query: "Go", Page: 1,
+		First: 1, Results: rankResults(f(1), f(2), f(3), f(4))})
+	c.Add(&QueryLogEntry{Type: QueryLogClick, Query: "go ", Page: 1,
+		Position: 3, Package: "github.com/a/p3"})
+	c.Add(&QueryLogEntry{Type: QueryLogClick, Query: "go", Page: 1,
+		Position: 1, Package: "github.com/a/p1"})
+	// no such search
+	c.Add(&QueryLogEntry{Type: QueryLogClick, Query: "db", Page: 1,
+		Position: 1, Package: "github.com/a/p1"})
+	// not the result at the position
+	c.Add(&QueryLogEntry{Type: QueryLogClick, Query: "go", Page: 1,
+		Position: 4, Package: "github.com/a/p1"})
+	// emitted when all clicks of the search are known
+	assert.Equals(t, "len(Pairs)", len(c.Pairs), 0)
+
+	// superseded by a new search
+	c.Add(&QueryLogEntry{Type: QueryLogSearch, Query: "go", Page: 1,
+		First: 1, Results: rankResults(f(1), f(2))})
+	// 1 is preferred to 2, 3 is preferred to 2 and 4, but not to the
+	// clicked 1
+	assert.Equals(t, "len(Pairs)", len(c.Pairs), 3)
+	for _, p := range c.Pairs {
+		assert.IsTrue(t, "Better not Worse", p.Worse[RankNameMatch] != 1 &&
+			p.Worse[RankNameMatch] != 3)
+	}
+
+	c.Add(&QueryLogEntry{Type: QueryLogClick, Query: "go", Page: 1,
+		Position: 2, Package: "github.com/a/p2"})
+	c.Finish()
+	assert.Equals(t, "len(Pairs)", len(c.Pairs), 4)
+	assert.Equals(t, "Better", c.Pairs[3].Better[RankNameMatch], 2.0)
+	assert.Equals(t, "Worse", c.Pairs[3].Worse[RankNameMatch], 1.0)
+}
+
+func TestTrainRankModel(t *testing.T) {
+	var pairs []RankPair
+	for i := 0; i < 50; i++ {
+		better := make([]float64, RankFeatureCount)
+		worse := make([]float64, RankFeatureCount)
+		// users prefer name matches to the static score
+		better[RankNameMatch], better[RankLogStatic] = 1, float64(i%5)
+		worse[RankNameMatch], worse[RankLogStatic] = 0, float64(i%5)+0.5
+		pairs = append(pairs, RankPair{Better: better, Worse: worse})
+	}
+	m := TrainRankModel(pairs, RankTrainOptions{
+		Epochs: 500,
+		Rate:   0.5,
+		L2:     0.01,
+	})
+	assert.NoErrorf(t, "Check: %v", m.Check())
+	assert.Equals(t, "Pairs", m.Pairs, 50)
+	for _, p := range pairs {
+		assert.IsTrue(t, "better", m.Score(p.Better) > m.Score(p.Worse))
+	}
+	assert.IsTrue(t, "no pairs",
+		TrainRankModel(nil, RankTrainOptions{}) == nil)
+}
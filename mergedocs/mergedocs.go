@@ -4,6 +4,7 @@ import (
 	//	"fmt"
 	"log"
 	"sync/atomic"
+	"time"
 
 	"github.com/daviddengcn/gcse"
 	"github.com/daviddengcn/sophie"
@@ -13,6 +14,7 @@ import (
 
 func main() {
 	log.Println("Merging new crawled docs back...")
+	gcse.LoadBanList(gcse.NewMemDB(gcse.CrawlerDBPath, gcse.KindBan))
 
 	fpDataRoot := sophie.LocalFsPath(gcse.DataRoot.S())
 
@@ -20,7 +22,10 @@ func main() {
 	outDocsUpdated := kv.DirOutput(fpDataRoot.Join("docs-updated"))
 	outDocsUpdated.Clean()
 	
-	var cntDeleted, cntUpdated, cntNewUnchange int64
+	// FirstSeen of the packages first seen in this run
+	mergeTime := time.Now()
+
+	var cntDeleted, cntUpdated, cntNewUnchange, cntNew int64
 	
 	job := mr.MrJob{
 		Source: []mr.Input{
@@ -38,6 +43,10 @@ func main() {
 							
 						pkg := key.(*sophie.RawString).String()
 						di := val.(*gcse.DocInfo)
+						if di.FirstSeen.IsZero() {
+							// docs merged before FirstSeen was introduced
+							di.FirstSeen = gcse.LegacyFirstSeen
+						}
 						act := gcse.NewDocAction{
 							Action:  gcse.NDA_UPDATE,
 							DocInfo: *di,
@@ -71,7 +80,14 @@ func main() {
 				ReduceF: func (key sophie.SophieWriter,
 					nextVal mr.SophierIterator, c []sophie.Collector) error {
 
+					if gcse.IsBanned(string(*key.(*sophie.RawString))) {
+						// not collect out to delete it
+						atomic.AddInt64(&cntDeleted, 1)
+						return nil
+					}
+
 					var act gcse.DocInfo
+					var firstSeen time.Time
 					isSet := false
 					isUpdated := false
 					for {
@@ -89,6 +105,10 @@ func main() {
 							atomic.AddInt64(&cntDeleted, 1)
 							return nil
 						}
+						if !cur.FirstSeen.IsZero() && (firstSeen.IsZero() ||
+							cur.FirstSeen.Before(firstSeen)) {
+							firstSeen = cur.FirstSeen
+						}
 						if !isSet {
 							isSet = true
 							act = cur.DocInfo
@@ -101,6 +121,13 @@ func main() {
 					}
 				
 					if isSet {
+						if firstSeen.IsZero() {
+							// a newly crawled package, whose LastUpdated
+							// can be long before it is seen
+							firstSeen = mergeTime
+							atomic.AddInt64(&cntNew, 1)
+						}
+						act.FirstSeen = firstSeen
 						if isUpdated {
 							atomic.AddInt64(&cntUpdated, 1)
 						} else {
@@ -126,6 +153,7 @@ func main() {
 	log.Printf("Deleted: %v", cntDeleted)
 	log.Printf("Updated: %v", cntUpdated)
 	log.Printf("NewUnchange: %v", cntNewUnchange)
+	log.Printf("New: %v", cntNew)
 
 	pDocs := gcse.DataRoot.Join(gcse.FnDocs)
 	pUpdated := gcse.DataRoot.Join("docs-updated")
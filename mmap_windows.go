@@ -0,0 +1,20 @@
+package gcse
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile reads the whole file into memory, pages are not shared among
+// processes on Windows.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,855 @@
+package gcse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/daviddengcn/go-index"
+	"github.com/daviddengcn/go-villa"
+	"github.com/daviddengcn/sophie/mr"
+)
+
+/*
+The memory-mapped index file (MMIndexFn) is laid out as follows, all integers
+are little-endian:
+
+	header       magic, docCount, fieldCount, fieldDirOff, docTableOff
+	doc data     for each doc, the encoded HitInfo without ReadmeData and
+	             Examples, followed by the ReadmeData and the encoded
+	             Examples
+	strings      field names and terms
+	postings     sorted uint32 docIDs of each term
+	term tables  for each field, mmTerm entries sorted by term
+	field dir    mmField entries
+	doc table    mmDoc entries
+
+Only the header and the tables are read when the index is opened, to check
+that all offsets are in the file. Postings and doc data are decoded from the
+mapped pages on demand, so the pages are shared by all processes mapping the
+same file and the ReadmeData and Examples are only touched when a package is
+rendered.
+*/
+
+// the magic must be changed whenever the encoding in encodeMMHit or
+// encodeMMExamples changes
+const mmIndexMagic = "GCSEMMI3"
+
+type mmHeader struct {
+	Magic       [8]byte
+	DocCount    uint32
+	FieldCount  uint32
+	FieldDirOff uint64
+	DocTableOff uint64
+}
+
+type mmField struct {
+	NameOff      uint64
+	NameLen      uint32
+	TermCount    uint32
+	TermTableOff uint64
+}
+
+type mmTerm struct {
+	TermOff   uint64
+	PostOff   uint64
+	TermLen   uint32
+	PostCount uint32
+}
+
+type mmDoc struct {
+	MetaOff     uint64
+	MetaLen     uint32
+	ReadmeLen   uint32
+	ExamplesLen uint32
+	_           uint32
+}
+
+var (
+	mmHeaderSize = binary.Size(mmHeader{})
+	mmFieldSize  = binary.Size(mmField{})
+	mmTermSize   = binary.Size(mmTerm{})
+	mmDocSize    = binary.Size(mmDoc{})
+)
+
+var errBadMMIndex = errors.New("Bad mmindex file")
+
+type mmEncoder struct {
+	villa.ByteSlice
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (e *mmEncoder) uvarint(v uint64) {
+	e.Write(e.buf[:binary.PutUvarint(e.buf[:], v)])
+}
+
+func (e *mmEncoder) varint(v int64) {
+	e.Write(e.buf[:binary.PutVarint(e.buf[:], v)])
+}
+
+func (e *mmEncoder) str(s string) {
+	e.uvarint(uint64(len(s)))
+	e.WriteString(s)
+}
+
+func (e *mmEncoder) strs(l []string) {
+	e.uvarint(uint64(len(l)))
+	for _, s := range l {
+		e.str(s)
+	}
+}
+
+func (e *mmEncoder) float(f float64) {
+	e.uvarint(math.Float64bits(f))
+}
+
+func (e *mmEncoder) time(t time.Time) {
+	if t.IsZero() {
+		e.varint(0)
+		return
+	}
+	e.varint(t.UnixNano())
+}
+
+type mmDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *mmDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errBadMMIndex
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *mmDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errBadMMIndex
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *mmDecoder) str() string {
+	l := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if l > uint64(len(d.data)) {
+		d.err = errBadMMIndex
+		return ""
+	}
+	s := string(d.data[:l])
+	d.data = d.data[l:]
+	return s
+}
+
+func (d *mmDecoder) strs() []string {
+	n := d.uvarint()
+	if n == 0 || d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.data)) {
+		// every string takes at least one byte
+		d.err = errBadMMIndex
+		return nil
+	}
+	l := make([]string, n)
+	for i := range l {
+		l[i] = d.str()
+	}
+	return l
+}
+
+func (d *mmDecoder) float() float64 {
+	return math.Float64frombits(d.uvarint())
+}
+
+func (d *mmDecoder) time() time.Time {
+	ns := d.varint()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// encodeMMHit encodes all fields of a HitInfo but ReadmeData and Examples.
+func encodeMMHit(e *mmEncoder, hit *HitInfo) {
+	e.str(hit.Name)
+	e.str(hit.Package)
+	e.str(hit.Author)
+	e.time(hit.LastUpdated)
+	e.time(hit.FirstSeen)
+	e.varint(int64(hit.StarCount))
+	e.str(hit.Synopsis)
+	e.str(hit.Description)
+	e.str(hit.ProjectURL)
+	e.str(hit.ReadmeFn)
+	e.strs(hit.Imports)
+	e.strs(hit.TestImports)
+	e.strs(hit.Exported)
+	e.str(hit.License)
+
+	e.strs(hit.Imported)
+	e.strs(hit.TestImported)
+	e.strs(hit.ImportantSentences)
+	e.float(hit.AssignedStarCount)
+	e.float(hit.StaticScore)
+	e.float(hit.TestStaticScore)
+	e.varint(int64(hit.StaticRank))
+}
+
+func encodeMMExamples(e *mmEncoder, examples []Example) {
+	e.uvarint(uint64(len(examples)))
+	for _, ex := range examples {
+		e.str(ex.Name)
+		e.str(ex.Doc)
+		e.str(ex.Code)
+		e.str(ex.Output)
+	}
+}
+
+func decodeMMExamples(d *mmDecoder) ([]Example, error) {
+	n := d.uvarint()
+	if n == 0 || d.err != nil {
+		return nil, d.err
+	}
+	if n > uint64(len(d.data)) {
+		return nil, errBadMMIndex
+	}
+	examples := make([]Example, n)
+	for i := range examples {
+		examples[i] = Example{
+			Name:   d.str(),
+			Doc:    d.str(),
+			Code:   d.str(),
+			Output: d.str(),
+		}
+	}
+	return examples, d.err
+}
+
+func decodeMMHit(d *mmDecoder, hit *HitInfo) error {
+	hit.Name = d.str()
+	hit.Package = d.str()
+	hit.Author = d.str()
+	hit.LastUpdated = d.time()
+	hit.FirstSeen = d.time()
+	hit.StarCount = int(d.varint())
+	hit.Synopsis = d.str()
+	hit.Description = d.str()
+	hit.ProjectURL = d.str()
+	hit.ReadmeFn = d.str()
+	hit.Imports = d.strs()
+	hit.TestImports = d.strs()
+	hit.Exported = d.strs()
+	hit.License = d.str()
+
+	hit.Imported = d.strs()
+	hit.TestImported = d.strs()
+	hit.ImportantSentences = d.strs()
+	hit.AssignedStarCount = d.float()
+	hit.StaticScore = d.float()
+	hit.TestStaticScore = d.float()
+	hit.StaticRank = int(d.varint())
+	return d.err
+}
+
+// MMIndexBuilder collects docs and saves them in the memory-mapped index
+// format.
+type MMIndexBuilder struct {
+	docs     []mmEncoder
+	readmes  []string
+	examples []mmEncoder
+	postings map[string]map[string][]uint32
+}
+
+func NewMMIndexBuilder() *MMIndexBuilder {
+	return &MMIndexBuilder{
+		postings: make(map[string]map[string][]uint32),
+	}
+}
+
+// AddDoc adds a doc with the tokens of its fields. DocIDs are assigned in the
+// order of adding, starting from zero.
+func (b *MMIndexBuilder) AddDoc(fields map[string]villa.StrSet, hit *HitInfo) int32 {
+	docID := uint32(len(b.docs))
+	b.docs = append(b.docs, mmEncoder{})
+	encodeMMHit(&b.docs[docID], hit)
+	b.readmes = append(b.readmes, hit.ReadmeData)
+	b.examples = append(b.examples, mmEncoder{})
+	if len(hit.Examples) > 0 {
+		encodeMMExamples(&b.examples[docID], hit.Examples)
+	}
+
+	for field, tokens := range fields {
+		terms, ok := b.postings[field]
+		if !ok {
+			terms = make(map[string][]uint32)
+			b.postings[field] = terms
+		}
+		for token := range tokens {
+			terms[token] = append(terms[token], docID)
+		}
+	}
+	return int32(docID)
+}
+
+func (b *MMIndexBuilder) DocCount() int {
+	return len(b.docs)
+}
+
+// Save writes the index to w.
+func (b *MMIndexBuilder) Save(w io.Writer) error {
+	fieldNames := make([]string, 0, len(b.postings))
+	for field := range b.postings {
+		fieldNames = append(fieldNames, field)
+	}
+	sort.Strings(fieldNames)
+	sortedTerms := make([][]string, len(fieldNames))
+	for i, field := range fieldNames {
+		terms := make([]string, 0, len(b.postings[field]))
+		for term := range b.postings[field] {
+			terms = append(terms, term)
+		}
+		sort.Strings(terms)
+		sortedTerms[i] = terms
+	}
+
+	// compute the offsets of all sections
+	off := uint64(mmHeaderSize)
+	docs := make([]mmDoc, len(b.docs))
+	for i := range b.docs {
+		docs[i] = mmDoc{
+			MetaOff:     off,
+			MetaLen:     uint32(len(b.docs[i].ByteSlice)),
+			ReadmeLen:   uint32(len(b.readmes[i])),
+			ExamplesLen: uint32(len(b.examples[i].ByteSlice)),
+		}
+		off += uint64(docs[i].MetaLen) + uint64(docs[i].ReadmeLen) +
+			uint64(docs[i].ExamplesLen)
+	}
+	fields := make([]mmField, len(fieldNames))
+	termTables := make([][]mmTerm, len(fieldNames))
+	for i, field := range fieldNames {
+		fields[i] = mmField{
+			NameOff:   off,
+			NameLen:   uint32(len(field)),
+			TermCount: uint32(len(sortedTerms[i])),
+		}
+		off += uint64(len(field))
+		termTables[i] = make([]mmTerm, len(sortedTerms[i]))
+		for j, term := range sortedTerms[i] {
+			termTables[i][j] = mmTerm{
+				TermOff:   off,
+				TermLen:   uint32(len(term)),
+				PostCount: uint32(len(b.postings[field][term])),
+			}
+			off += uint64(len(term))
+		}
+	}
+	for i := range fieldNames {
+		for j := range termTables[i] {
+			termTables[i][j].PostOff = off
+			off += 4 * uint64(termTables[i][j].PostCount)
+		}
+	}
+	for i := range fields {
+		fields[i].TermTableOff = off
+		off += uint64(mmTermSize * len(termTables[i]))
+	}
+	header := mmHeader{
+		DocCount:    uint32(len(docs)),
+		FieldCount:  uint32(len(fields)),
+		FieldDirOff: off,
+		DocTableOff: off + uint64(mmFieldSize*len(fields)),
+	}
+	copy(header.Magic[:], mmIndexMagic)
+
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	for i := range b.docs {
+		bw.Write(b.docs[i].ByteSlice)
+		bw.WriteString(b.readmes[i])
+		bw.Write(b.examples[i].ByteSlice)
+	}
+	for i, field := range fieldNames {
+		bw.WriteString(field)
+		for _, term := range sortedTerms[i] {
+			bw.WriteString(term)
+		}
+	}
+	var buf [4]byte
+	for i, field := range fieldNames {
+		for _, term := range sortedTerms[i] {
+			for _, docID := range b.postings[field][term] {
+				binary.LittleEndian.PutUint32(buf[:], docID)
+				bw.Write(buf[:])
+			}
+		}
+	}
+	for i := range termTables {
+		if err := binary.Write(bw, binary.LittleEndian, termTables[i]); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(bw, binary.LittleEndian, fields); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, docs); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// SaveMMIndex writes the docs in ts to w in the memory-mapped index format.
+func SaveMMIndex(w io.Writer, ts *index.TokenSetSearcher) error {
+	b := NewMMIndexBuilder()
+	if err := ts.Search(nil, func(docID int32, data interface{}) error {
+		hit, ok := data.(HitInfo)
+		if !ok {
+			return errNotDocInfo
+		}
+		b.AddDoc(IndexFields(&hit), &hit)
+		return nil
+	}); err != nil {
+		return err
+	}
+	return b.Save(w)
+}
+
+// MMIndexShardFn returns the file name of the i-th shard of a sharded
+// MMIndex.
+func MMIndexShardFn(i int) string {
+	return fmt.Sprintf("%s-%d.mmidx", KindIndex, i)
+}
+
+// SaveMMIndexShards indexes the docs in docDB into shards by
+// CalcPackagePartition and saves them as MMIndexShardFn(i) in segm. Shards are
+// built one by one, each by a pass over docDB, so only the HitInfos of one
+// shard are in memory at a time. Packages banned by the loaded ban list are
+// skipped. Returns the number of docs indexed.
+func SaveMMIndexShards(segm Segment, docDB mr.Input, shards int) (int, error) {
+	st, err := collectIndexStats(docDB)
+	if err != nil {
+		return 0, err
+	}
+	// CalcPackagePartition may return totalParts itself
+	shardOf := func(pkg string) int {
+		return CalcPackagePartition(pkg, shards) % shards
+	}
+
+	// the StaticRank of a doc is the number of docs with higher StaticScores
+	log.Printf("Calculating static scores ...")
+	scores := make([]float64, 0, st.docCount)
+	if err := forEachDoc(docDB, func(_ string, docInfo *DocInfo) error {
+		hit := HitInfo{DocInfo: *docInfo}
+		st.scoreHit(&hit)
+		scores = append(scores, hit.StaticScore)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(scores)))
+	rankOf := func(score float64) int {
+		return sort.Search(len(scores), func(i int) bool {
+			return scores[i] <= score
+		})
+	}
+
+	for i := 0; i < shards; i++ {
+		log.Printf("Making HitInfos of shard %d ...", i)
+		var hits []HitInfo
+		if err := forEachDoc(docDB, func(pkg string, docInfo *DocInfo) error {
+			if shardOf(pkg) != i {
+				return nil
+			}
+			hit := st.hitInfo(docInfo)
+			hit.StaticRank = rankOf(hit.StaticScore)
+			hits = append(hits, hit)
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+		sortHits(hits)
+
+		b := NewMMIndexBuilder()
+		for j := range hits {
+			b.AddDoc(IndexFields(&hits[j]), &hits[j])
+		}
+		hits = nil
+
+		f, err := segm.Join(MMIndexShardFn(i)).Create()
+		if err != nil {
+			return 0, err
+		}
+		err = b.Save(f)
+		f.Close()
+		if err != nil {
+			return 0, villa.NestErrorf(err, "save shard %d", i)
+		}
+		DumpMemStats()
+	}
+	return len(scores), nil
+}
+
+// MMIndex is a read-only index on a memory-mapped file. Its methods are safe
+// for concurrent use. The file is unmapped by Close after all uses reserved by
+// Acquire are released, so an MMIndex can be replaced while searches are
+// still using it. All returned values are copied out of the mapped data.
+type MMIndex struct {
+	data     []byte
+	docCount int
+	fields   map[string]mmField
+	docTable []byte
+	unmap    func() error
+
+	mu      sync.Mutex
+	refs    int
+	closing bool
+}
+
+// OpenMMIndex maps an index file saved by MMIndexBuilder.
+func OpenMMIndex(fn villa.Path) (*MMIndex, error) {
+	f, err := fn.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() < int64(mmHeaderSize) || int64(int(st.Size())) != st.Size() {
+		return nil, villa.NestErrorf(errBadMMIndex, "size of %v: %d", fn,
+			st.Size())
+	}
+	data, err := mmapFile(f, int(st.Size()))
+	if err != nil {
+		return nil, villa.NestErrorf(err, "mmap %v", fn)
+	}
+	idx, err := NewMMIndex(data)
+	if err != nil {
+		munmapFile(data)
+		return nil, villa.NestErrorf(err, "open %v", fn)
+	}
+	idx.unmap = func() error {
+		return munmapFile(data)
+	}
+	return idx, nil
+}
+
+// Acquire reserves the mapped data for a use of the index until the matching
+// Release. Returns false if the index is closed, in which case it must not be
+// used.
+func (idx *MMIndex) Acquire() bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.closing {
+		return false
+	}
+	idx.refs++
+	return true
+}
+
+// Release ends a use reserved by Acquire. The last Release after Close
+// unmaps the file.
+func (idx *MMIndex) Release() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.refs--
+	if idx.refs == 0 && idx.closing {
+		if err := idx.unmapData(); err != nil {
+			log.Printf("Unmapping index failed: %v", err)
+		}
+	}
+}
+
+// Close unmaps the file, or lets the last Release do it if the index is in
+// use. The index must not be used after Close without an Acquire.
+func (idx *MMIndex) Close() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.closing {
+		return nil
+	}
+	idx.closing = true
+	if idx.refs > 0 {
+		return nil
+	}
+	return idx.unmapData()
+}
+
+// unmapData unmaps the file if mapped. idx.mu is held.
+func (idx *MMIndex) unmapData() error {
+	if idx.unmap == nil {
+		return nil
+	}
+	err := idx.unmap()
+	idx.unmap, idx.data, idx.docTable = nil, nil, nil
+	return err
+}
+
+// inData returns whether the n bytes at off are in data.
+func inData(data []byte, off, n uint64) bool {
+	size := uint64(len(data))
+	return off <= size && n <= size-off
+}
+
+// NewMMIndex returns an MMIndex on the data of an index file.
+func NewMMIndex(data []byte) (*MMIndex, error) {
+	var header mmHeader
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian,
+		&header); err != nil {
+		return nil, err
+	}
+	if string(header.Magic[:]) != mmIndexMagic {
+		return nil, villa.NestErrorf(errBadMMIndex, "magic %q", header.Magic[:])
+	}
+	fieldDirLen := uint64(mmFieldSize) * uint64(header.FieldCount)
+	docTableLen := uint64(mmDocSize) * uint64(header.DocCount)
+	if !inData(data, header.FieldDirOff, fieldDirLen) ||
+		!inData(data, header.DocTableOff, docTableLen) {
+		return nil, villa.NestErrorf(errBadMMIndex, "tables out of the file")
+	}
+	fieldDirEnd := header.FieldDirOff + fieldDirLen
+	docTableEnd := header.DocTableOff + docTableLen
+
+	idx := &MMIndex{
+		data:     data,
+		docCount: int(header.DocCount),
+		fields:   make(map[string]mmField, header.FieldCount),
+		docTable: data[header.DocTableOff:docTableEnd],
+	}
+	fields := make([]mmField, header.FieldCount)
+	if err := binary.Read(bytes.NewReader(data[header.FieldDirOff:fieldDirEnd]),
+		binary.LittleEndian, fields); err != nil {
+		return nil, err
+	}
+	for i, fld := range fields {
+		if !inData(data, fld.NameOff, uint64(fld.NameLen)) ||
+			!inData(data, fld.TermTableOff,
+				uint64(mmTermSize)*uint64(fld.TermCount)) {
+			return nil, villa.NestErrorf(errBadMMIndex, "field %d", i)
+		}
+		name := string(data[fld.NameOff : fld.NameOff+uint64(fld.NameLen)])
+		for j := 0; j < int(fld.TermCount); j++ {
+			t := idx.term(fld, j)
+			if !inData(data, t.TermOff, uint64(t.TermLen)) ||
+				!inData(data, t.PostOff, 4*uint64(t.PostCount)) {
+				return nil, villa.NestErrorf(errBadMMIndex, "term %d of %s", j,
+					name)
+			}
+		}
+		idx.fields[name] = fld
+	}
+	for docID := 0; docID < idx.docCount; docID++ {
+		if _, err := idx.doc(int32(docID)); err != nil {
+			return nil, villa.NestErrorf(err, "doc %d", docID)
+		}
+	}
+	return idx, nil
+}
+
+func (idx *MMIndex) DocCount() int {
+	return idx.docCount
+}
+
+func (idx *MMIndex) term(fld mmField, i int) mmTerm {
+	b := idx.data[fld.TermTableOff+uint64(i*mmTermSize):]
+	return mmTerm{
+		TermOff:   binary.LittleEndian.Uint64(b),
+		PostOff:   binary.LittleEndian.Uint64(b[8:]),
+		TermLen:   binary.LittleEndian.Uint32(b[16:]),
+		PostCount: binary.LittleEndian.Uint32(b[20:]),
+	}
+}
+
+// postings returns the raw posting list of a token in a field, nil if not
+// found.
+func (idx *MMIndex) postings(field, token string) []byte {
+	fld, ok := idx.fields[field]
+	if !ok {
+		return nil
+	}
+	tk := []byte(token)
+	n := int(fld.TermCount)
+	i := sort.Search(n, func(i int) bool {
+		t := idx.term(fld, i)
+		return bytes.Compare(idx.data[t.TermOff:t.TermOff+uint64(t.TermLen)], tk) >= 0
+	})
+	if i >= n {
+		return nil
+	}
+	t := idx.term(fld, i)
+	if !bytes.Equal(idx.data[t.TermOff:t.TermOff+uint64(t.TermLen)], tk) {
+		return nil
+	}
+	return idx.data[t.PostOff : t.PostOff+4*uint64(t.PostCount)]
+}
+
+// TokenDocList returns the sorted docIDs of the docs containing token in
+// field.
+func (idx *MMIndex) TokenDocList(field, token string) []int32 {
+	post := idx.postings(field, token)
+	if len(post) == 0 {
+		return nil
+	}
+	docIDs := make([]int32, len(post)/4)
+	for i := range docIDs {
+		docIDs[i] = int32(binary.LittleEndian.Uint32(post[4*i:]))
+	}
+	return docIDs
+}
+
+// TokenDocCount returns the number of docs containing token in field.
+func (idx *MMIndex) TokenDocCount(field, token string) int {
+	return len(idx.postings(field, token)) / 4
+}
+
+func (idx *MMIndex) doc(docID int32) (mmDoc, error) {
+	if docID < 0 || int(docID) >= idx.docCount {
+		return mmDoc{}, fmt.Errorf("docID %d out of range", docID)
+	}
+	b := idx.docTable[int(docID)*mmDocSize:]
+	d := mmDoc{
+		MetaOff:     binary.LittleEndian.Uint64(b),
+		MetaLen:     binary.LittleEndian.Uint32(b[8:]),
+		ReadmeLen:   binary.LittleEndian.Uint32(b[12:]),
+		ExamplesLen: binary.LittleEndian.Uint32(b[16:]),
+	}
+	if !inData(idx.data, d.MetaOff, uint64(d.MetaLen)+uint64(d.ReadmeLen)+
+		uint64(d.ExamplesLen)) {
+		return mmDoc{}, errBadMMIndex
+	}
+	return d, nil
+}
+
+// HitInfo returns the HitInfo of a doc with ReadmeData and Examples unset.
+func (idx *MMIndex) HitInfo(docID int32) (HitInfo, error) {
+	var hit HitInfo
+	d, err := idx.doc(docID)
+	if err != nil {
+		return hit, err
+	}
+	err = decodeMMHit(&mmDecoder{
+		data: idx.data[d.MetaOff : d.MetaOff+uint64(d.MetaLen)],
+	}, &hit)
+	return hit, err
+}
+
+// ReadmeData returns the ReadmeData of a doc, which is not returned by
+// HitInfo or Search.
+func (idx *MMIndex) ReadmeData(docID int32) string {
+	d, err := idx.doc(docID)
+	if err != nil {
+		return ""
+	}
+	start := d.MetaOff + uint64(d.MetaLen)
+	return string(idx.data[start : start+uint64(d.ReadmeLen)])
+}
+
+// Examples returns the Examples of a doc, which are not returned by HitInfo
+// or Search.
+func (idx *MMIndex) Examples(docID int32) ([]Example, error) {
+	d, err := idx.doc(docID)
+	if err != nil {
+		return nil, err
+	}
+	if d.ExamplesLen == 0 {
+		return nil, nil
+	}
+	start := d.MetaOff + uint64(d.MetaLen) + uint64(d.ReadmeLen)
+	return decodeMMExamples(&mmDecoder{
+		data: idx.data[start : start+uint64(d.ExamplesLen)],
+	})
+}
+
+// Search calls output with the docID and the HitInfo (without ReadmeData and
+// Examples) of every doc matching all tokens of all fields in query, in the
+// order of docIDs. All docs are matched if no token is specified.
+func (idx *MMIndex) Search(query map[string]villa.StrSet,
+	output func(docID int32, data interface{}) error) error {
+	var lists [][]byte
+	for field, tokens := range query {
+		for token := range tokens {
+			post := idx.postings(field, token)
+			if len(post) == 0 {
+				return nil
+			}
+			lists = append(lists, post)
+		}
+	}
+
+	emit := func(docID int32) error {
+		hit, err := idx.HitInfo(docID)
+		if err != nil {
+			return err
+		}
+		return output(docID, hit)
+	}
+
+	if len(lists) == 0 {
+		for docID := 0; docID < idx.docCount; docID++ {
+			if err := emit(int32(docID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// iterate the shortest list and look up the docIDs in others
+	villa.SortF(len(lists), func(i, j int) bool {
+		return len(lists[i]) < len(lists[j])
+	}, func(i, j int) {
+		lists[i], lists[j] = lists[j], lists[i]
+	})
+	pos := make([]int, len(lists))
+mainLoop:
+	for i := 0; i < len(lists[0]); i += 4 {
+		docID := binary.LittleEndian.Uint32(lists[0][i:])
+		for j := 1; j < len(lists); j++ {
+			l, n := lists[j], len(lists[j])/4
+			p := pos[j] + sort.Search(n-pos[j], func(k int) bool {
+				return binary.LittleEndian.Uint32(l[4*(pos[j]+k):]) >= docID
+			})
+			pos[j] = p
+			if p >= n {
+				return nil
+			}
+			if binary.LittleEndian.Uint32(l[4*p:]) != docID {
+				continue mainLoop
+			}
+		}
+		if err := emit(int32(docID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package gcse
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-villa"
+	"github.com/daviddengcn/sophie"
+	"github.com/daviddengcn/sophie/mr"
+)
+
+func TestMMIndex(t *testing.T) {
+	hits := []HitInfo{
+		{
+			DocInfo: DocInfo{
+				Package:     "github.com/daviddengcn/gcse",
+				Name:        "gcse",
+				Description: "Go search engine",
+				ReadmeData:  "The readme of gcse",
+				LastUpdated: time.Unix(1400000000, 0),
+				StarCount:   -1,
+				Exported:    []string{"Index", "Segment"},
+				License:     "MIT",
+				Examples: []Example{{
+					Name:   "Index",
+					Code:   "fmt.Println(1)",
+					Output: "1",
+				}},
+			},
+			Imported:    []string{"github.com/daviddengcn/gcse/indexer"},
+			StaticScore: 1.5,
+		}, {
+			DocInfo: DocInfo{
+				Package:     "github.com/daviddengcn/gcse/indexer",
+				Name:        "main",
+				Description: "Index generator of go search engine",
+				Imports:     []string{"github.com/daviddengcn/gcse"},
+			},
+			StaticRank: 1,
+		}, {
+			DocInfo: DocInfo{
+				Package: "github.com/daviddengcn/go-villa",
+				Name:    "villa",
+			},
+			StaticRank: 2,
+		},
+	}
+
+	b := NewMMIndexBuilder()
+	for i := range hits {
+		b.AddDoc(IndexFields(&hits[i]), &hits[i])
+	}
+	var buf villa.ByteSlice
+	if err := b.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	idx, err := NewMMIndex(buf)
+	if err != nil {
+		t.Fatalf("NewMMIndex: %v", err)
+	}
+	assert.Equals(t, "DocCount", idx.DocCount(), len(hits))
+
+	search := func(query map[string]villa.StrSet) (pkgs []string) {
+		if err := idx.Search(query, func(docID int32, data interface{}) error {
+			pkgs = append(pkgs, data.(HitInfo).Package)
+			return nil
+		}); err != nil {
+			t.Errorf("Search %v: %v", query, err)
+		}
+		return pkgs
+	}
+	assert.StringEquals(t, "all", search(nil), "[github.com/daviddengcn/gcse "+
+		"github.com/daviddengcn/gcse/indexer github.com/daviddengcn/go-villa]")
+	assert.StringEquals(t, "search", search(map[string]villa.StrSet{
+		IndexTextField: villa.NewStrSet(NormWord("search"), NormWord("engine")),
+	}), "[github.com/daviddengcn/gcse github.com/daviddengcn/gcse/indexer]")
+	assert.StringEquals(t, "pkg", search(map[string]villa.StrSet{
+		IndexPkgField: villa.NewStrSet("github.com/daviddengcn/gcse/indexer"),
+	}), "[github.com/daviddengcn/gcse/indexer]")
+	assert.StringEquals(t, "license", search(map[string]villa.StrSet{
+		IndexLicenseField: villa.NewStrSet("mit"),
+	}), "[github.com/daviddengcn/gcse]")
+	assert.StringEquals(t, "not found", search(map[string]villa.StrSet{
+		IndexTextField: villa.NewStrSet(NormWord("search"), "nonexist"),
+	}), "[]")
+
+	assert.StringEquals(t, "TokenDocList", idx.TokenDocList(IndexTextField,
+		NormWord("engine")), "[0 1]")
+	assert.StringEquals(t, "TokenDocList", idx.TokenDocList(IndexNameField,
+		NormWord("engine")), "[]")
+
+	for i := range hits {
+		hit, err := idx.HitInfo(int32(i))
+		if err != nil {
+			t.Errorf("HitInfo(%d): %v", i, err)
+			continue
+		}
+		readme, examples := hits[i].ReadmeData, hits[i].Examples
+		hits[i].ReadmeData, hits[i].Examples = "", nil
+		assert.StringEquals(t, fmt.Sprintf("hit %d", i), hit, hits[i])
+		assert.Equals(t, fmt.Sprintf("readme %d", i),
+			idx.ReadmeData(int32(i)), readme)
+		exs, err := idx.Examples(int32(i))
+		if err != nil {
+			t.Errorf("Examples(%d): %v", i, err)
+		}
+		assert.StringEquals(t, fmt.Sprintf("examples %d", i), exs, examples)
+	}
+}
+
+func TestOpenMMIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gcse")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fn := villa.Path(dir).Join(MMIndexFn)
+
+	hit := HitInfo{DocInfo: DocInfo{Package: "github.com/daviddengcn/gcse"}}
+	b := NewMMIndexBuilder()
+	b.AddDoc(IndexFields(&hit), &hit)
+	f, err := fn.Create()
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := b.Save(f); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	f.Close()
+
+	idx, err := OpenMMIndex(fn)
+	if err != nil {
+		t.Fatalf("OpenMMIndex: %v", err)
+	}
+	assert.StringEquals(t, "TokenDocList", idx.TokenDocList(IndexPkgField,
+		hit.Package), "[0]")
+
+	// closed while in use, unmapped at the last Release
+	assert.Equals(t, "Acquire", idx.Acquire(), true)
+	if err := idx.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	assert.StringEquals(t, "TokenDocList after Close", idx.TokenDocList(
+		IndexPkgField, hit.Package), "[0]")
+	assert.Equals(t, "Acquire after Close", idx.Acquire(), false)
+	idx.Release()
+	assert.Equals(t, "unmapped", idx.data == nil, true)
+
+	if err := fn.WriteFile([]byte("not an index file!!!!!!!!!!!!!!!!!!!!!"),
+		0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := OpenMMIndex(fn); err == nil {
+		t.Errorf("OpenMMIndex of a bad file should fail")
+	}
+}
+
+func TestMMIndexCorrupt(t *testing.T) {
+	hit := HitInfo{DocInfo: DocInfo{Package: "github.com/daviddengcn/gcse"}}
+	b := NewMMIndexBuilder()
+	b.AddDoc(IndexFields(&hit), &hit)
+	var buf villa.ByteSlice
+	if err := b.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	idx, err := NewMMIndex(buf)
+	if err != nil {
+		t.Fatalf("NewMMIndex: %v", err)
+	}
+	fld := idx.fields[IndexPkgField]
+
+	// the PostOff of the first term of the pkg field out of the file
+	data := append([]byte(nil), buf...)
+	binary.LittleEndian.PutUint64(data[fld.TermTableOff+8:], 1<<40)
+	if _, err := NewMMIndex(data); err == nil {
+		t.Errorf("NewMMIndex with a bad posting offset should fail")
+	}
+
+	// a doc out of the file
+	data = append([]byte(nil), buf...)
+	binary.LittleEndian.PutUint64(data[len(data)-mmDocSize:], ^uint64(0))
+	if _, err := NewMMIndex(data); err == nil {
+		t.Errorf("NewMMIndex with a bad doc offset should fail")
+	}
+
+	// truncated
+	if _, err := NewMMIndex(buf[:len(buf)-1]); err == nil {
+		t.Errorf("NewMMIndex of a truncated file should fail")
+	}
+}
+
+// docsInput returns an mr.Input of docs in one part.
+func docsInput(docs []DocInfo) mr.Input {
+	return &mr.InputStruct{
+		PartCountF: func() (int, error) {
+			return 1, nil
+		},
+		IteratorF: func(int) (sophie.IterateCloser, error) {
+			index := 0
+			return &sophie.IterateCloserStruct{
+				NextF: func(key, val sophie.SophieReader) error {
+					if index >= len(docs) {
+						return sophie.EOF
+					}
+					*key.(*sophie.RawString) = sophie.RawString(
+						docs[index].Package)
+					*val.(*DocInfo) = docs[index]
+
+					index++
+					return nil
+				},
+			}, nil
+		},
+	}
+}
+
+func TestSaveMMIndexShards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gcse")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	segm := segment(dir)
+
+	docs := []DocInfo{
+		{Package: "github.com/a/b", Name: "b"},
+		{Package: "github.com/c/d", Name: "d",
+			Imports: []string{"github.com/a/b"}},
+		{Package: "github.com/e/f", Name: "f",
+			Imports: []string{"github.com/a/b", "github.com/c/d"}},
+		{Package: "github.com/g/h", Name: "h"},
+		{Package: "github.com/i/j", Name: "j",
+			Description: "Package j is a package"},
+	}
+	ts, err := Index(docsInput(docs))
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	ranks := make(map[string]int)
+	ts.Search(nil, func(docID int32, data interface{}) error {
+		hit := data.(HitInfo)
+		ranks[hit.Package] = hit.StaticRank
+		return nil
+	})
+
+	const shards = 3
+	cnt, err := SaveMMIndexShards(segm, docsInput(docs), shards)
+	if err != nil {
+		t.Fatalf("SaveMMIndexShards: %v", err)
+	}
+	assert.Equals(t, "count", cnt, len(docs))
+	total := 0
+	for i := 0; i < shards; i++ {
+		idx, err := OpenMMIndex(segm.Join(MMIndexShardFn(i)))
+		if err != nil {
+			t.Fatalf("OpenMMIndex of shard %d: %v", i, err)
+		}
+		idx.Search(nil, func(docID int32, data interface{}) error {
+			hit := data.(HitInfo)
+			assert.Equals(t, hit.Package+" shard",
+				CalcPackagePartition(hit.Package, shards)%shards, i)
+			assert.Equals(t, hit.Package+" StaticRank", hit.StaticRank,
+				ranks[hit.Package])
+			return nil
+		})
+		total += idx.DocCount()
+		idx.Close()
+	}
+	assert.Equals(t, "total docs", total, len(docs))
+}
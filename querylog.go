@@ -0,0 +1,301 @@
+package gcse
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daviddengcn/go-villa"
+)
+
+const (
+	fnQueryLog = "log.jsonl"
+
+	// Types of QueryLogEntry
+	QueryLogSearch = "search"
+	QueryLogClick  = "click"
+)
+
+// QueryLogEntry is a line of the query log, a search or a click on a search
+// result.
+type QueryLogEntry struct {
+	// QueryLogSearch or QueryLogClick
+	Type  string
+	Time  time.Time
+	Query string
+	// 1-based page of the results
+	Page int
+
+	// fields of a search
+	Tokens []string `json:",omitempty"`
+	// total number of results
+	Hits int `json:",omitempty"`
+	// 1-based position of the first result on the page and the number of
+	// results on it
+	First int `json:",omitempty"`
+	Shown int `json:",omitempty"`
+	// in milliseconds
+	Latency float64 `json:",omitempty"`
+	// whether the page was served from the cache
+	Cached bool `json:",omitempty"`
+	// the results on the page
+	Results []QueryLogResult `json:",omitempty"`
+
+	// fields of a click
+	Package string `json:",omitempty"`
+	// 1-based position of the result clicked
+	Position int `json:",omitempty"`
+}
+
+// QueryLogResult is a result of a search in the query log.
+type QueryLogResult struct {
+	Package string
+	// RankFeatures of the result
+	Features []float64
+}
+
+// QueryLogger appends QueryLogEntrys to a segment of QueryLogSegments, a new
+// one every rotate duration. Segments are done when rotated or closed.
+type QueryLogger struct {
+	segms  Segments
+	rotate time.Duration
+
+	mu     sync.Mutex
+	segm   Segment
+	f      *os.File
+	w      *bufio.Writer
+	opened time.Time
+}
+
+// NewQueryLogger returns a QueryLogger of segms. Undone segments left by a
+// previous run are marked done, since the entries in them are complete
+// except the last one, which is skipped by ReadQueryLog.
+func NewQueryLogger(segms Segments, rotate time.Duration) *QueryLogger {
+	all, err := segms.ListAll()
+	if err != nil {
+		log.Printf("List query log segments failed: %v", err)
+	}
+	for _, segm := range all {
+		if !segm.IsDone() {
+			if err := segm.Done(); err != nil {
+				log.Printf("Done query log %v failed: %v", segm, err)
+			}
+		}
+	}
+	return &QueryLogger{
+		segms:  segms,
+		rotate: rotate,
+	}
+}
+
+// closeSegment closes the current segment and marks it done. l.mu is held.
+func (l *QueryLogger) closeSegment() error {
+	if l.segm == nil {
+		return nil
+	}
+	segm := l.segm
+	l.segm = nil
+	if err := l.w.Flush(); err != nil {
+		l.f.Close()
+		return err
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	return segm.Done()
+}
+
+// openSegment opens a new segment. l.mu is held.
+func (l *QueryLogger) openSegment(now time.Time) error {
+	segm, err := l.segms.GenMaxSegment()
+	if err != nil {
+		return err
+	}
+	f, err := segm.Join(fnQueryLog).Create()
+	if err != nil {
+		return err
+	}
+	l.segm, l.f, l.w, l.opened = segm, f, bufio.NewWriter(f), now
+	log.Printf("Query log segment %v opened", segm)
+	return nil
+}
+
+// Log appends an entry to the log. Errors are logged and the entry is
+// dropped.
+func (l *QueryLogger) Log(ent *QueryLogEntry) {
+	if ent.Time.IsZero() {
+		ent.Time = time.Now()
+	}
+	line, err := json.Marshal(ent)
+	if err != nil {
+		log.Printf("Marshal query log entry failed: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.segm != nil && ent.Time.Sub(l.opened) >= l.rotate {
+		if err := l.closeSegment(); err != nil {
+			log.Printf("Close query log segment failed: %v", err)
+		}
+	}
+	if l.segm == nil {
+		if err := l.openSegment(ent.Time); err != nil {
+			log.Printf("Open query log segment failed: %v", err)
+			return
+		}
+	}
+	l.w.Write(line)
+	if err := l.w.WriteByte('\n'); err != nil {
+		log.Printf("Write query log failed: %v", err)
+	}
+}
+
+// Flush writes the buffered entries to the file.
+func (l *QueryLogger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.segm == nil {
+		return nil
+	}
+	return l.w.Flush()
+}
+
+// Close closes the current segment and marks it done.
+func (l *QueryLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.closeSegment()
+}
+
+// ReadQueryLog calls output with each entry in a segment of the query log.
+// Malformed lines, e.g. a partly written last line, are skipped.
+func ReadQueryLog(segm Segment, output func(ent *QueryLogEntry) error) error {
+	f, err := segm.Join(fnQueryLog).Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var ent QueryLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &ent); err != nil {
+			log.Printf("Malformed line in query log %v: %v", segm, err)
+			continue
+		}
+		if err := output(&ent); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// QueryCount is a query and the number of times it is searched.
+type QueryCount struct {
+	Query string
+	Count int
+}
+
+// QueryStats is the statistics aggregated from query logs.
+type QueryStats struct {
+	Searches int
+	Clicks   int
+	// key: normalized query
+	Queries     map[string]int
+	ZeroResults map[string]int
+	// the number of times a result at position i+1 is shown and clicked
+	Impressions    []int
+	PositionClicks []int
+}
+
+func NewQueryStats() *QueryStats {
+	return &QueryStats{
+		Queries:     make(map[string]int),
+		ZeroResults: make(map[string]int),
+	}
+}
+
+// normQuery normalizes a query for counting.
+func normQuery(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// growInts returns l with at least n elements.
+func growInts(l []int, n int) []int {
+	for len(l) < n {
+		l = append(l, 0)
+	}
+	return l
+}
+
+// Add adds an entry to the statistics. Searches of pages other than the
+// first one are counted as impressions only.
+func (s *QueryStats) Add(ent *QueryLogEntry) {
+	switch ent.Type {
+	case QueryLogSearch:
+		if ent.Shown > 0 {
+			last := ent.First + ent.Shown - 1
+			s.Impressions = growInts(s.Impressions, last)
+			for pos := ent.First; pos <= last; pos++ {
+				s.Impressions[pos-1]++
+			}
+		}
+		if ent.Page > 1 {
+			return
+		}
+		s.Searches++
+		q := normQuery(ent.Query)
+		s.Queries[q]++
+		if ent.Hits == 0 {
+			s.ZeroResults[q]++
+		}
+	case QueryLogClick:
+		if ent.Position <= 0 {
+			return
+		}
+		s.Clicks++
+		s.PositionClicks = growInts(s.PositionClicks, ent.Position)
+		s.PositionClicks[ent.Position-1]++
+	}
+}
+
+// TopQueries returns at most n queries in counts, the most frequent first.
+func TopQueries(counts map[string]int, n int) []QueryCount {
+	l := make([]QueryCount, 0, len(counts))
+	for q, cnt := range counts {
+		l = append(l, QueryCount{Query: q, Count: cnt})
+	}
+	villa.SortF(len(l), func(i, j int) bool {
+		if l[i].Count != l[j].Count {
+			return l[i].Count > l[j].Count
+		}
+		return l[i].Query < l[j].Query
+	}, func(i, j int) {
+		l[i], l[j] = l[j], l[i]
+	})
+	if len(l) > n {
+		l = l[:n]
+	}
+	return l
+}
+
+// CTR returns the click-through rate of the results at a 1-based position.
+func (s *QueryStats) CTR(pos int) float64 {
+	if pos <= 0 || pos > len(s.Impressions) || s.Impressions[pos-1] == 0 {
+		return 0
+	}
+	clicks := 0
+	if pos <= len(s.PositionClicks) {
+		clicks = s.PositionClicks[pos-1]
+	}
+	return float64(clicks) / float64(s.Impressions[pos-1])
+}
@@ -0,0 +1,70 @@
+package gcse
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-villa"
+)
+
+func TestQueryLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	assert.NoErrorf(t, "TempDir: %v", err)
+	defer os.RemoveAll(dir)
+	segms := segments(villa.Path(dir))
+
+	now := time.Now()
+	l := NewQueryLogger(segms, time.Hour)
+	l.Log(&QueryLogEntry{Type: QueryLogSearch, Query: "go", Time: now})
+	l.Log(&QueryLogEntry{Type: QueryLogClick, Query: "go", Position: 1,
+		Time: now.Add(time.Minute)})
+	// rotated
+	l.Log(&QueryLogEntry{Type: QueryLogSearch, Query: "db",
+		Time: now.Add(2 * time.Hour)})
+	assert.NoErrorf(t, "Flush: %v", l.Flush())
+
+	dones, _ := segms.ListDones()
+	assert.Equals(t, "len(dones)", len(dones), 1)
+	var queries []string
+	ReadQueryLog(dones[0], func(ent *QueryLogEntry) error {
+		queries = append(queries, ent.Type+":"+ent.Query)
+		return nil
+	})
+	assert.StringEquals(t, "queries", queries, []string{"search:go", "click:go"})
+
+	// undone segments are marked done by a new logger
+	NewQueryLogger(segms, time.Hour)
+	dones, _ = segms.ListDones()
+	assert.Equals(t, "len(dones)", len(dones), 2)
+}
+
+func TestQueryStats(t *testing.T) {
+	stats := NewQueryStats()
+	for _, ent := range []QueryLogEntry{
+		{Type: QueryLogSearch, Query: "Web ", Page: 1, Hits: 20, First: 1,
+			Shown: 10},
+		{Type: QueryLogSearch, Query: "web", Page: 2, Hits: 20, First: 11,
+			Shown: 10},
+		{Type: QueryLogSearch, Query: "xyzzy", Page: 1},
+		{Type: QueryLogSearch, Query: "db", Page: 1, Hits: 2, First: 1,
+			Shown: 2},
+		{Type: QueryLogClick, Query: "web", Position: 1},
+		{Type: QueryLogClick, Query: "web", Position: 12},
+	} {
+		ent := ent
+		stats.Add(&ent)
+	}
+	assert.Equals(t, "Searches", stats.Searches, 3)
+	assert.Equals(t, "Clicks", stats.Clicks, 2)
+	assert.StringEquals(t, "TopQueries", TopQueries(stats.Queries, 2),
+		[]QueryCount{{Query: "db", Count: 1}, {Query: "web", Count: 1}})
+	assert.Equals(t, "Queries[web]", stats.Queries["web"], 1)
+	assert.Equals(t, "ZeroResults[xyzzy]", stats.ZeroResults["xyzzy"], 1)
+	assert.Equals(t, "CTR(1)", stats.CTR(1), 0.5)
+	assert.Equals(t, "CTR(2)", stats.CTR(2), 0.0)
+	assert.Equals(t, "CTR(12)", stats.CTR(12), 1.0)
+	assert.Equals(t, "CTR(30)", stats.CTR(30), 0.0)
+}
@@ -177,15 +177,22 @@ func removeHost(pkg string) string {
 	return pkg
 }
 
-func CalcMatchScore(doc *HitInfo, tokenList []string,
-	textIdfs, nameIdfs []float64) float64 {
+// MatchComponents are the sums of the IDFs of the query tokens matched in
+// parts of a doc.
+type MatchComponents struct {
+	Synopsis  float64
+	Sentences float64
+	Name      float64
+	Package   float64
+}
 
+func CalcMatchComponents(doc *HitInfo, tokenList []string,
+	textIdfs, nameIdfs []float64) MatchComponents {
+	var c MatchComponents
 	if len(tokenList) == 0 {
-		return 1.
+		return c
 	}
 
-	s := float64(0.02 * float64(len(tokenList)))
-
 	filteredSyn := filterURLs([]byte(doc.Synopsis))
 	synopsis := string(bytes.ToLower(filteredSyn))
 	synTokens := AppendTokens(nil, filteredSyn)
@@ -209,21 +216,37 @@ func CalcMatchScore(doc *HitInfo, tokenList []string,
 		nameIdf := nameIdfs[i]
 
 		if matchToken(token, synopsis, synTokens) {
-			s += 0.25 * textIdf
+			c.Synopsis += textIdf
 		}
 
 		if matchToken(token, isText, isTokens) {
-			s += 0.25 * textIdf
+			c.Sentences += textIdf
 		}
 
 		if matchToken(token, name, nameTokens) {
-			s += 0.25 * nameIdf
+			c.Name += nameIdf
 		}
 
 		if matchToken(token, pkg, pkgTokens) {
-			s += 0.1 * textIdf
+			c.Package += textIdf
 		}
 	}
 
-	return s
+	return c
+}
+
+// MatchScoreOf returns the match score of a doc by its MatchComponents.
+func MatchScoreOf(c MatchComponents, tokens int) float64 {
+	if tokens == 0 {
+		return 1.
+	}
+
+	return 0.02*float64(tokens) + 0.25*c.Synopsis + 0.25*c.Sentences +
+		0.25*c.Name + 0.1*c.Package
+}
+
+func CalcMatchScore(doc *HitInfo, tokenList []string,
+	textIdfs, nameIdfs []float64) float64 {
+	return MatchScoreOf(CalcMatchComponents(doc, tokenList, textIdfs,
+		nameIdfs), len(tokenList))
 }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daviddengcn/gcse"
+)
+
+// checkAdmin returns whether the request is authenticated as the admin by
+// HTTP basic authentication. If not, an error is written to w.
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if gcse.ServerAdminPassword == "" {
+		http.Error(w, "Admin is disabled.", http.StatusForbidden)
+		return false
+	}
+	user, password, ok := r.BasicAuth()
+	if ok && subtle.ConstantTimeCompare([]byte(user),
+		[]byte(gcse.ServerAdminUser)) == 1 && subtle.ConstantTimeCompare(
+		[]byte(password), []byte(gcse.ServerAdminPassword)) == 1 {
+		return true
+	}
+	if ok {
+		log.Printf("Admin authentication of %s failed from %s", user,
+			clientIP(r))
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="gcse admin"`)
+	http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+	return false
+}
+
+// sameOrigin returns false if a request is posted from a page of another site,
+// since the browser sends the basic authentication anyway.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Referer()
+	}
+	if origin == "" {
+		// not from a browser
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// serializes the modifications of the ban list
+var banMu sync.Mutex
+
+// banned packages are removed from the docs by the next run of mergedocs
+type banResult struct {
+	Path   string
+	Banned bool
+}
+
+// pageAdminBan bans (action=ban) or unbans (action=unban) a package, or all
+// packages under an import prefix if prefix=1, with POST. The result is
+// returned in JSON.
+func pageAdminBan(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		ApiContent(w, http.StatusMethodNotAllowed, "POST only", "")
+		return
+	}
+	if !sameOrigin(r) {
+		ApiContent(w, http.StatusForbidden, "Cross-site request", "")
+		return
+	}
+	path := strings.TrimSpace(r.FormValue("pkg"))
+
+	banMu.Lock()
+	defer banMu.Unlock()
+
+	db := gcse.NewMemDB(gcse.CrawlerDBPath, gcse.KindBan)
+	res := banResult{Path: path}
+	switch r.FormValue("action") {
+	case "ban":
+		if err := gcse.BanPackage(db, path, r.FormValue("prefix") == "1",
+			r.FormValue("reason")); err != nil {
+			ApiContent(w, http.StatusBadRequest, err.Error(), "")
+			return
+		}
+		res.Banned = true
+	case "unban":
+		if !gcse.UnbanPackage(db, path) {
+			ApiContent(w, http.StatusNotFound, path+" is not banned", "")
+			return
+		}
+	default:
+		ApiContent(w, http.StatusBadRequest, "Unknown action", "")
+		return
+	}
+	if err := db.Sync(); err != nil {
+		log.Printf("Sync ban list failed: %v", err)
+		ApiContent(w, http.StatusInternalServerError, err.Error(), "")
+		return
+	}
+	gcse.LoadBanList(db)
+	banListChanged()
+
+	log.Printf("Admin %s %s from %s", r.FormValue("action"), path,
+		clientIP(r))
+	ApiContent(w, http.StatusOK, res, "")
+}
+
+// number of recent crawling failures shown at /admin
+const adminFailureCount = 50
+
+type adminStatus struct {
+	// result of the action, if any
+	Message string
+
+	IndexSegment string
+	IndexUpdated time.Time
+	DocCount     int
+
+	PackageCount int
+	PersonCount  int
+	BanCount     int
+	// names of the import segments not yet processed by the crawler
+	PendingImports []string
+	// nil if no reindexing requested
+	ReindexRequest *gcse.ReindexRequest
+	Failures       []gcse.CrawlFailure
+	// saved by the crawler, nil if not yet
+	GithubBudgets []gcse.GithubBudget
+}
+
+// newCountCache returns a fileCache of the number of entries of the MemDB of a
+// kind in CrawlerDBPath. The db is not kept in memory.
+func newCountCache(kind string) *fileCache {
+	return &fileCache{
+		fn: gcse.CrawlerDBPath.Join(kind + ".gob"),
+		load: func() interface{} {
+			return gcse.NewMemDB(gcse.CrawlerDBPath, kind).Count()
+		},
+	}
+}
+
+// the numbers shown at /admin, loaded again only when the crawler syncs
+var (
+	packageCountCache = newCountCache(gcse.KindPackage)
+	personCountCache  = newCountCache(gcse.KindPerson)
+	banCountCache     = newCountCache(gcse.KindBan)
+	failuresCache     = &fileCache{
+		fn: gcse.CrawlerDBPath.Join(gcse.KindFailure + ".gob"),
+		load: func() interface{} {
+			return gcse.RecentCrawlFailures(gcse.NewMemDB(gcse.CrawlerDBPath,
+				gcse.KindFailure), adminFailureCount)
+		},
+	}
+	githubBudgetsCache = &fileCache{
+		fn: gcse.CrawlerDBPath.Join(gcse.FnGithubBudgets),
+		load: func() interface{} {
+			budgets, err := gcse.LoadGithubBudgets()
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("LoadGithubBudgets failed: %v", err)
+			}
+			return budgets
+		},
+	}
+)
+
+func loadAdminStatus() *adminStatus {
+	st := &adminStatus{
+		IndexUpdated:   indexUpdated,
+		ReindexRequest: gcse.PendingReindexRequest(),
+	}
+	if indexSegment != nil {
+		st.IndexSegment = indexSegment.Name()
+	}
+	if indexDB, _ := indexDBBox.Get().(indexSearcher); indexDB != nil {
+		st.DocCount = indexDB.DocCount()
+	}
+
+	st.PackageCount = packageCountCache.Get().(int)
+	st.PersonCount = personCountCache.Get().(int)
+	st.BanCount = banCountCache.Get().(int)
+	st.Failures = failuresCache.Get().([]gcse.CrawlFailure)
+	st.GithubBudgets = githubBudgetsCache.Get().([]gcse.GithubBudget)
+
+	if dones, err := gcse.ImportSegments.ListDones(); err == nil {
+		for _, segm := range dones {
+			st.PendingImports = append(st.PendingImports, segm.Name())
+		}
+	} else {
+		log.Printf("ImportSegments.ListDones failed: %v", err)
+	}
+	return st
+}
+
+// adminAction performs an action posted at /admin and returns the message of
+// the result.
+func adminAction(r *http.Request) string {
+	action := r.FormValue("action")
+	log.Printf("Admin %s from %s", action, clientIP(r))
+	switch action {
+	case "reload":
+		tried, err := loadIndex(true)
+		if tried {
+			metrics.ObserveIndexLoad(err)
+		}
+		if err != nil {
+			return fmt.Sprintf("Reloading index failed: %v", err)
+		}
+		return "Index reloaded."
+	case "crawl":
+		pkg, err := gcse.NormalizeImportPath(r.FormValue("pkg"))
+		if err != nil {
+			return err.Error()
+		}
+		if !gcse.AppendPackages([]string{pkg}) {
+			return "Scheduling " + pkg + " failed."
+		}
+		return pkg + " will be crawled on the next run of the crawler."
+	case "reindex":
+		if err := gcse.RequestReindex(); err != nil {
+			return fmt.Sprintf("Requesting reindexing failed: %v", err)
+		}
+		return "Reindexing requested."
+	}
+	return "Unknown action: " + action
+}
+
+// pageAdmin shows the status of the index and the crawler, and performs the
+// actions posted.
+func pageAdmin(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	var msg string
+	if r.Method == "POST" {
+		if !sameOrigin(r) {
+			http.Error(w, "Cross-site request.", http.StatusForbidden)
+			return
+		}
+		msg = adminAction(r)
+	}
+	st := loadAdminStatus()
+	st.Message = msg
+	if err := templates.ExecuteTemplate(w, "admin.html", st); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
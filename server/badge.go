@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/daviddengcn/gcse"
+)
+
+const (
+	badgeColorBlue  = "#007ec6"
+	badgeColorGreen = "#4c1"
+	badgeColorGrey  = "#9f9f9f"
+)
+
+var badgeTemplate = template.Must(template.New("badge").Parse(
+	`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20">
+<linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<mask id="a"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></mask>
+<g mask="url(#a)"><path fill="#555" d="M0 0h{{.LabelWidth}}v20H0z"/><path fill="{{.Color}}" d="M{{.LabelWidth}} 0h{{.ValueWidth}}v20H{{.LabelWidth}}z"/><path fill="url(#b)" d="M0 0h{{.Width}}v20H0z"/></g>
+<g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="15" fill="#010101" fill-opacity=".3">{{html .Label}}</text><text x="{{.LabelX}}" y="14">{{html .Label}}</text>
+<text x="{{.ValueX}}" y="15" fill="#010101" fill-opacity=".3">{{html .Value}}</text><text x="{{.ValueX}}" y="14">{{html .Value}}</text>
+</g>
+</svg>
+`))
+
+type badge struct {
+	Label, Value, Color    string
+	LabelWidth, ValueWidth int
+}
+
+// an approximation of the text width in Verdana 11px with paddings
+func badgeTextWidth(text string) int {
+	return utf8.RuneCountInString(text)*7 + 10
+}
+
+func (b badge) Width() int {
+	return b.LabelWidth + b.ValueWidth
+}
+
+func (b badge) LabelX() float64 {
+	return float64(b.LabelWidth) / 2
+}
+
+func (b badge) ValueX() float64 {
+	return float64(b.LabelWidth) + float64(b.ValueWidth)/2
+}
+
+func newBadge(label, value, color string) badge {
+	return badge{
+		Label:      label,
+		Value:      value,
+		Color:      color,
+		LabelWidth: badgeTextWidth(label),
+		ValueWidth: badgeTextWidth(value),
+	}
+}
+
+// pageBadge renders an SVG shield of the rank, the number of importers or
+// the stars of a package.
+func pageBadge(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.FormValue("id"))
+	kind := strings.ToLower(strings.TrimSpace(r.FormValue("kind")))
+
+	var b badge
+	switch kind {
+	case "", "rank":
+		b = newBadge("gcse rank", "", badgeColorBlue)
+	case "imported":
+		b = newBadge("imported by", "", badgeColorBlue)
+	case "stars":
+		b = newBadge("stars", "", badgeColorBlue)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown badge kind: %s", kind),
+			http.StatusBadRequest)
+		return
+	}
+
+	var doc gcse.HitInfo
+	if id == "" || !findPackage(id, &doc) {
+		b.Value, b.ValueWidth = "not found", badgeTextWidth("not found")
+		b.Color = badgeColorGrey
+		w.Header().Set("Cache-Control", "no-cache")
+		writeBadge(w, b)
+		return
+	}
+
+	if setIndexCacheHeaders(w, r, time.Hour) {
+		return
+	}
+
+	var value string
+	switch kind {
+	case "", "rank":
+		value = fmt.Sprintf("#%d", doc.StaticRank+1)
+		if doc.StaticRank < 1000 {
+			b.Color = badgeColorGreen
+		}
+	case "imported":
+		value = fmt.Sprintf("%d", len(doc.Imported))
+	case "stars":
+		if doc.StarCount < 0 {
+			doc.StarCount = 0
+		}
+		value = fmt.Sprintf("%d", doc.StarCount)
+	}
+	b.Value, b.ValueWidth = value, badgeTextWidth(value)
+	writeBadge(w, b)
+}
+
+func writeBadge(w http.ResponseWriter, b badge) {
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	if err := badgeTemplate.Execute(w, b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
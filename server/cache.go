@@ -0,0 +1,239 @@
+package main
+
+import (
+	"compress/gzip"
+	"container/list"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daviddengcn/gcse"
+)
+
+// the generation of the loaded ban list, part of the ETag of the pages
+// depending on the index since banned packages are hidden from them
+var banGeneration struct {
+	sync.Mutex
+	gen     int
+	updated time.Time
+}
+
+// banListChanged is called after the ban list is loaded again. The pages
+// depending on the index are invalidated.
+func banListChanged() {
+	banGeneration.Lock()
+	banGeneration.gen++
+	banGeneration.updated = time.Now()
+	banGeneration.Unlock()
+	searchCache.Clear()
+}
+
+// etagMatches returns whether an If-None-Match header matches a weak ETag by
+// the weak comparison.
+func etagMatches(inm, etag string) bool {
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") ==
+			strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIndexCache sets the caching headers of a response depending only on
+// the loaded index segment and the ban list. Returns true if the client's
+// copy is still valid. The ETag is weak since the body may be gzipped.
+func checkIndexCache(w http.ResponseWriter, r *http.Request,
+	maxAge time.Duration) bool {
+	if indexSegment == nil || r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	banGeneration.Lock()
+	gen, modified := banGeneration.gen, banGeneration.updated
+	banGeneration.Unlock()
+	if indexUpdated.After(modified) {
+		modified = indexUpdated
+	}
+	etag := fmt.Sprintf(`W/"%s-%d"`, indexSegment.Name(), gen)
+	w.Header().Set("Cache-Control",
+		fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	t, err := time.Parse(http.TimeFormat, r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.Truncate(time.Second).After(t)
+}
+
+// setIndexCacheHeaders calls checkIndexCache and writes StatusNotModified if
+// the client's copy is still valid, in which case true is returned.
+func setIndexCacheHeaders(w http.ResponseWriter, r *http.Request,
+	maxAge time.Duration) bool {
+	if !checkIndexCache(w, r, maxAge) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// gzipResponseWriter compresses the body if it is not of a precompressed
+// content type. The gzip.Writer is created at the first Write so that bodiless
+// responses, e.g. StatusNotModified, are left untouched. Partial content is
+// never compressed since the byte range is of the uncompressed body.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	passThrough bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if code == http.StatusPartialContent {
+		w.passThrough = true
+	}
+	if code != http.StatusNotModified && code != http.StatusNoContent {
+		w.prepare()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) prepare() {
+	if w.gz != nil || w.passThrough {
+		return
+	}
+	h := w.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+	}
+	ct := h.Get("Content-Type")
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" ||
+		strings.HasPrefix(ct, "image/") && !strings.HasPrefix(ct, "image/svg") ||
+		strings.HasPrefix(ct, "application/x-shockwave-flash") {
+		w.passThrough = true
+		return
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", "gzip")
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.gz == nil && !w.passThrough {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.prepare()
+	}
+	if w.passThrough {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.gz.Write(p)
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// lruCache is a thread-safe cache which evicts the least recently used entry
+// when it is full.
+type lruCache struct {
+	sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type lruEntry struct {
+	key string
+	val interface{}
+}
+
+func newLRUCache(maxEntries int) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruEntry).val, true
+}
+
+func (c *lruCache) Put(key string, val interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*lruEntry).val = val
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, val: val})
+	for c.ll.Len() > c.maxEntries {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*lruEntry).key)
+	}
+}
+
+// Clear removes all entries.
+func (c *lruCache) Clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+func (c *lruCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.ll.Len()
+}
+
+const searchCacheSize = 1000
+
+// a rendered search page in searchCache
+type cachedSearch struct {
+	Page []byte
+	// the query log entry of the search, copied for each hit of the cache
+	Log gcse.QueryLogEntry
+}
+
+// rendered search pages keyed by index segment, query and page, cleared
+// when a new index is loaded
+var searchCache = newLRUCache(searchCacheSize)
+
+func searchCacheKey(q string, p int) string {
+	segm := ""
+	if indexSegment != nil {
+		segm = indexSegment.Name()
+	}
+	return fmt.Sprintf("%s\x00%d\x00%s", segm, p, q)
+}
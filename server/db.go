@@ -2,12 +2,50 @@ package main
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/daviddengcn/gcse"
-	"github.com/daviddengcn/go-index"
 	"github.com/daviddengcn/go-villa"
-	"strings"
 )
 
+// fileCache caches a value loaded from a file, e.g. a MemDB of the crawler,
+// and loads it again only when the file is modified.
+type fileCache struct {
+	fn   villa.Path
+	load func() interface{}
+
+	mu    sync.Mutex
+	val   interface{}
+	mtime time.Time
+}
+
+// Get returns the cached value, loaded again if the file is modified since.
+func (c *fileCache) Get() interface{} {
+	var mtime time.Time
+	if st, err := c.fn.Stat(); err == nil {
+		mtime = st.ModTime()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.val == nil || !mtime.Equal(c.mtime) {
+		c.val, c.mtime = c.load(), mtime
+	}
+	return c.val
+}
+
+// newMemDBCache returns a fileCache of the MemDB of a kind in CrawlerDBPath.
+func newMemDBCache(kind string) *fileCache {
+	return &fileCache{
+		fn: gcse.CrawlerDBPath.Join(kind + ".gob"),
+		load: func() interface{} {
+			return gcse.NewMemDB(gcse.CrawlerDBPath, kind)
+		},
+	}
+}
+
 type StatItem struct {
 	Name    string
 	Package string
@@ -71,14 +109,56 @@ func inProjects(projs villa.StrSet, pkg string) bool {
 	return false
 }
 
+// shardOfDoc returns the index of the shard of a docID by the bases of the
+// shards.
+func shardOfDoc(bases []int32, docID int32) int {
+	for i := len(bases) - 1; i > 0; i-- {
+		if docID >= bases[i] {
+			return i
+		}
+	}
+	return 0
+}
+
+// topHotHits returns at most n hits of different projects with the highest
+// static-scores in the hot hits of the shards.
+func topHotHits(shards [][]gcse.HitInfo, n int) []gcse.HitInfo {
+	var hits []gcse.HitInfo
+	for _, l := range shards {
+		hits = append(hits, l...)
+	}
+	villa.SortF(len(hits), func(i, j int) bool {
+		return hits[i].StaticScore > hits[j].StaticScore
+	}, func(i, j int) {
+		hits[i], hits[j] = hits[j], hits[i]
+	})
+
+	var top []gcse.HitInfo
+	var projects villa.StrSet
+	for _, hit := range hits {
+		if len(top) >= n {
+			break
+		}
+		if !inProjects(projects, hit.ProjectURL) {
+			top = append(top, hit)
+			projects.Put(hit.ProjectURL)
+		}
+	}
+	return top
+}
+
 func statTops(N int) []StatList {
-	indexDB := indexDBBox.Get().(*index.TokenSetSearcher)
+	indexDB, release := acquireIndex()
+	defer release()
 	if indexDB == nil {
 		return nil
 	}
 
-	var topStaticScores []gcse.HitInfo
-	var tssProjects villa.StrSet
+	// the docs of each shard are sorted by static-scores, the hot packages
+	// are selected from the ones of each shard
+	_, bases := searchShards(indexDB)
+	hotOfShards := make([][]gcse.HitInfo, len(bases))
+	hotProjects := make([]villa.StrSet, len(bases))
 
 	topImported := NewTopN(func(a, b interface{}) int {
 		ia, ib := a.(gcse.HitInfo), b.(gcse.HitInfo)
@@ -95,16 +175,19 @@ func statTops(N int) []StatList {
 
 	indexDB.Search(nil, func(docID int32, data interface{}) error {
 		hit := data.(gcse.HitInfo)
+		if gcse.IsBanned(hit.Package) {
+			return nil
+		}
 		orgName := hit.Name
 		hit.Name = packageShowName(hit.Name, hit.Package)
 
-		// assuming all packages has been sorted by static-scores.
-		if len(topStaticScores) < N {
+		s := shardOfDoc(bases, docID)
+		if len(hotOfShards[s]) < N {
 			if len(hit.Imported) > 0 &&
 				orgName != "" && orgName != "main" &&
-				!inProjects(tssProjects, hit.ProjectURL) {
-				topStaticScores = append(topStaticScores, hit)
-				tssProjects.Put(hit.ProjectURL)
+				!inProjects(hotProjects[s], hit.ProjectURL) {
+				hotOfShards[s] = append(hotOfShards[s], hit)
+				hotProjects[s].Put(hit.ProjectURL)
 			}
 		}
 
@@ -121,6 +204,7 @@ func statTops(N int) []StatList {
 		return nil
 	})
 
+	topStaticScores := topHotHits(hotOfShards, N)
 	tlStaticScore := StatList{
 		Name:  "Hot",
 		Info:  "refs stars",
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/daviddengcn/gcse"
+	"github.com/daviddengcn/go-villa"
+)
+
+const feedMaxEntries = 50
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published,omitempty"`
+	Updated   string      `xml:"updated"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Summary   string      `xml:"summary,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// feedHit is a hit with the time it is shown as updated in a feed.
+type feedHit struct {
+	gcse.HitInfo
+	Updated time.Time
+}
+
+// sortFeedHits sorts hits by Updated in descending order and returns at most
+// feedMaxEntries of them.
+func sortFeedHits(hits []feedHit) []feedHit {
+	villa.SortF(len(hits), func(i, j int) bool {
+		return hits[i].Updated.After(hits[j].Updated)
+	}, func(i, j int) {
+		hits[i], hits[j] = hits[j], hits[i]
+	})
+	if len(hits) > feedMaxEntries {
+		hits = hits[:feedMaxEntries]
+	}
+	return hits
+}
+
+func writeFeed(w http.ResponseWriter, r *http.Request, title string,
+	hits []feedHit) {
+	base := "http://" + r.Host
+	updated := indexUpdated
+	if len(hits) > 0 && hits[0].Updated.After(updated) {
+		updated = hits[0].Updated
+	}
+
+	feed := atomFeed{
+		Title: title,
+		ID:    base + r.URL.RequestURI(),
+		Link: []atomLink{
+			{Rel: "self", Href: base + r.URL.RequestURI()},
+			{Href: base + "/"},
+		},
+		Updated: atomTime(updated),
+		Author:  atomPerson{Name: "Go Search"},
+		Entries: make([]atomEntry, 0, len(hits)),
+	}
+	for _, hit := range hits {
+		link := viewURL(base, hit.Package)
+		entry := atomEntry{
+			Title:   packageShowName(hit.Name, hit.Package) + " - " + hit.Package,
+			ID:      link,
+			Link:    atomLink{Href: link},
+			Updated: atomTime(hit.Updated),
+			Summary: hit.Synopsis,
+		}
+		if hit.FirstSeen.After(gcse.LegacyFirstSeen) {
+			entry.Published = atomTime(hit.FirstSeen)
+		}
+		if hit.Author != "" {
+			entry.Author = &atomPerson{Name: hit.Author}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	writeXML(w, "application/atom+xml; charset=utf-8", feed)
+}
+
+// pageFeed returns the packages matching query q (and/or from author) which
+// were updated since the previous index segment.
+func pageFeed(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.FormValue("q"))
+	author := strings.TrimSpace(r.FormValue("author"))
+
+	indexDB, release := acquireIndex()
+	defer release()
+
+	since := indexPrevUpdated
+	var hits []feedHit
+	appendHit := func(hit gcse.HitInfo) {
+		if gcse.IsBanned(hit.Package) {
+			return
+		}
+		if author != "" && !strings.EqualFold(hit.Author, author) {
+			return
+		}
+		if !hit.LastUpdated.After(since) {
+			return
+		}
+		hits = append(hits, feedHit{HitInfo: hit, Updated: hit.LastUpdated})
+	}
+
+	if q != "" {
+		results, _, err := search(indexDB, q, 0)
+		if err != nil {
+			searchError(w, err)
+			return
+		}
+		for _, hit := range results.Hits {
+			appendHit(hit.HitInfo)
+		}
+	} else if indexDB != nil {
+		indexDB.Search(nil, func(docID int32, data interface{}) error {
+			appendHit(data.(gcse.HitInfo))
+			return nil
+		})
+	}
+
+	title := "Go Search - Updated packages"
+	switch {
+	case q != "" && author != "":
+		title += " related to " + q + " by " + author
+	case q != "":
+		title += " related to " + q
+	case author != "":
+		title += " by " + author
+	}
+	writeFeed(w, r, title, sortFeedHits(hits))
+}
+
+// pageFeedNew returns the packages first seen since the previous index
+// segment.
+func pageFeedNew(w http.ResponseWriter, r *http.Request) {
+	indexDB, release := acquireIndex()
+	defer release()
+
+	since := indexPrevUpdated
+	var hits []feedHit
+	if indexDB != nil {
+		indexDB.Search(nil, func(docID int32, data interface{}) error {
+			hit := data.(gcse.HitInfo)
+			if hit.FirstSeen.After(since) && !gcse.IsBanned(hit.Package) {
+				hits = append(hits, feedHit{HitInfo: hit, Updated: hit.FirstSeen})
+			}
+			return nil
+		})
+	}
+
+	writeFeed(w, r, "Go Search - New packages", sortFeedHits(hits))
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/daviddengcn/go-villa"
+)
+
+// histogram is a cumulative histogram in the form of the Prometheus
+// exposition format.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // counts[i] is the number of values <= bounds[i]
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) Observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) writeTo(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep,
+			strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+type requestKey struct {
+	handler string
+	code    int
+}
+
+// serverMetrics collects the metrics exposed at /metrics.
+type serverMetrics struct {
+	sync.Mutex
+	requests  map[requestKey]uint64
+	latencies map[string]*histogram
+	hits      *histogram
+
+	indexLoads        uint64
+	indexLoadFailures uint64
+}
+
+var latencyBounds = []float64{
+	.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+var metrics = &serverMetrics{
+	requests:  make(map[requestKey]uint64),
+	latencies: make(map[string]*histogram),
+	hits:      newHistogram(0, 1, 10, 100, 1000, 10000, 100000),
+}
+
+func (m *serverMetrics) ObserveRequest(handler string, code int,
+	dur time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.requests[requestKey{handler, code}]++
+	h, ok := m.latencies[handler]
+	if !ok {
+		h = newHistogram(latencyBounds...)
+		m.latencies[handler] = h
+	}
+	h.Observe(dur.Seconds())
+}
+
+func (m *serverMetrics) ObserveSearch(hits int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.hits.Observe(float64(hits))
+}
+
+func (m *serverMetrics) ObserveIndexLoad(err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if err != nil {
+		m.indexLoadFailures++
+	} else {
+		m.indexLoads++
+	}
+}
+
+func (m *serverMetrics) writeTo(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+
+	fmt.Fprintln(w, "# HELP gcse_http_requests_total Number of HTTP requests.")
+	fmt.Fprintln(w, "# TYPE gcse_http_requests_total counter")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	villa.SortF(len(keys), func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		return keys[i].code < keys[j].code
+	}, func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "gcse_http_requests_total{handler=%q,code=\"%d\"} %d\n",
+			k.handler, k.code, m.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP gcse_http_request_duration_seconds Latencies of HTTP requests.")
+	fmt.Fprintln(w, "# TYPE gcse_http_request_duration_seconds histogram")
+	handlers := make([]string, 0, len(m.latencies))
+	for h := range m.latencies {
+		handlers = append(handlers, h)
+	}
+	sort.Strings(handlers)
+	for _, h := range handlers {
+		m.latencies[h].writeTo(w, "gcse_http_request_duration_seconds",
+			fmt.Sprintf("handler=%q", h))
+	}
+
+	fmt.Fprintln(w, "# HELP gcse_search_hits Number of hits of searches.")
+	fmt.Fprintln(w, "# TYPE gcse_search_hits histogram")
+	m.hits.writeTo(w, "gcse_search_hits", "")
+
+	fmt.Fprintln(w, "# HELP gcse_index_loads_total Number of indexes loaded.")
+	fmt.Fprintln(w, "# TYPE gcse_index_loads_total counter")
+	fmt.Fprintf(w, "gcse_index_loads_total %d\n", m.indexLoads)
+	fmt.Fprintln(w, "# HELP gcse_index_load_failures_total Number of failures in loading indexes.")
+	fmt.Fprintln(w, "# TYPE gcse_index_load_failures_total counter")
+	fmt.Fprintf(w, "gcse_index_load_failures_total %d\n", m.indexLoadFailures)
+}
+
+func pageMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.writeTo(w)
+
+	docCount := 0
+	if indexDB, _ := indexDBBox.Get().(indexSearcher); indexDB != nil {
+		docCount = indexDB.DocCount()
+	}
+	fmt.Fprintln(w, "# HELP gcse_index_docs Number of packages in the loaded index.")
+	fmt.Fprintln(w, "# TYPE gcse_index_docs gauge")
+	fmt.Fprintf(w, "gcse_index_docs %d\n", docCount)
+	if !indexUpdated.IsZero() {
+		fmt.Fprintln(w, "# HELP gcse_index_age_seconds Seconds since the loaded index was generated.")
+		fmt.Fprintln(w, "# TYPE gcse_index_age_seconds gauge")
+		fmt.Fprintf(w, "gcse_index_age_seconds %g\n",
+			time.Since(indexUpdated).Seconds())
+	}
+}
+
+// pageHealthz reports the server process is alive.
+func pageHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// non-zero if the server is draining, i.e. shutting down or releasing the
+// index
+var draining int32
+
+func setDraining(d bool) {
+	if d {
+		atomic.StoreInt32(&draining, 1)
+	} else {
+		atomic.StoreInt32(&draining, 0)
+	}
+}
+
+// pageReadyz reports whether the server is ready for searching, i.e. an index
+// has been loaded and the server is not draining.
+func pageReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if atomic.LoadInt32(&draining) != 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("draining\n"))
+		return
+	}
+	if indexDB, _ := indexDBBox.Get().(indexSearcher); indexDB == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("index not loaded\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// statusResponseWriter records the status code of a response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daviddengcn/gcse"
+)
+
+// nil if the query log is disabled
+var queryLogger *gcse.QueryLogger
+
+func startQueryLog() {
+	if gcse.ServerQueryLogRotate <= 0 {
+		return
+	}
+	queryLogger = gcse.NewQueryLogger(gcse.QueryLogSegments,
+		gcse.ServerQueryLogRotate)
+	go func() {
+		for {
+			time.Sleep(30 * time.Second)
+			if err := queryLogger.Flush(); err != nil {
+				log.Printf("Flush query log failed: %v", err)
+			}
+		}
+	}()
+}
+
+func stopQueryLog() {
+	if queryLogger == nil {
+		return
+	}
+	if err := queryLogger.Close(); err != nil {
+		log.Printf("Close query log failed: %v", err)
+	}
+}
+
+func logQuery(ent *gcse.QueryLogEntry) {
+	if queryLogger != nil {
+		queryLogger.Log(ent)
+	}
+}
+
+// clickURL returns the link of a search result at a 1-based position, which
+// is logged by pageClick.
+func clickURL(q string, p, pos int, pkg string) string {
+	return "/click?" + url.Values{
+		"q":   {q},
+		"p":   {strconv.Itoa(p)},
+		"pos": {strconv.Itoa(pos)},
+		"id":  {pkg},
+	}.Encode()
+}
+
+// pageClick logs a click on a search result and redirects to the package.
+// Clicks over the rate limit are redirected without being logged.
+func pageClick(w http.ResponseWriter, r *http.Request) {
+	pkg := strings.TrimSpace(r.FormValue("id"))
+	if pkg == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	if wait := checkRateLimit(w, r, "click"); wait > 0 {
+		http.Redirect(w, r, "/view?id="+url.QueryEscape(pkg), http.StatusFound)
+		return
+	}
+	p, _ := strconv.Atoi(r.FormValue("p"))
+	pos, _ := strconv.Atoi(r.FormValue("pos"))
+	logQuery(&gcse.QueryLogEntry{
+		Type:     gcse.QueryLogClick,
+		Query:    strings.TrimSpace(r.FormValue("q")),
+		Page:     p,
+		Package:  pkg,
+		Position: pos,
+	})
+	http.Redirect(w, r, "/view?id="+url.QueryEscape(pkg), http.StatusFound)
+}
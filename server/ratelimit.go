@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daviddengcn/gcse"
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket for each client.
+type rateLimiter struct {
+	sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastPurge time.Time
+}
+
+func newRateLimiter(rl gcse.RateLimit) *rateLimiter {
+	return &rateLimiter{
+		rate:    rl.Rate,
+		burst:   float64(rl.Burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Take consumes a token of the client. Returns zero if a token is available,
+// otherwise the duration to wait for the next token.
+func (rl *rateLimiter) Take(client string, now time.Time) time.Duration {
+	if rl.rate <= 0 {
+		return 0
+	}
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	if now.Sub(rl.lastPurge) > time.Minute {
+		rl.purge(now)
+	}
+
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[client] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+}
+
+// purge removes buckets which have been refilled to full, they are the same as
+// new ones.
+func (rl *rateLimiter) purge(now time.Time) {
+	for client, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, client)
+		}
+	}
+	rl.lastPurge = now
+}
+
+var rateLimiters = make(map[string]*rateLimiter)
+
+// networks of gcse.ServerTrustedProxies
+var trustedProxies []*net.IPNet
+
+func init() {
+	for name, rl := range gcse.ServerRateLimits {
+		rateLimiters[name] = newRateLimiter(rl)
+	}
+	trustedProxies = parseTrustedProxies(gcse.ServerTrustedProxies)
+}
+
+// parseTrustedProxies parses IPs and CIDRs of trusted proxies. Invalid ones are
+// logged and ignored.
+func parseTrustedProxies(proxies []string) (nets []*net.IPNet) {
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Printf("Invalid trusted proxy %q: %v", proxy, err)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client. If the request is from a trusted
+// proxy, the right-most address in X-Forwarded-For not of a trusted proxy is
+// used, since the addresses to the left of it are set by the client.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// checkRateLimit returns zero if the request to endpoint is allowed,
+// otherwise the duration the client should wait, in which case the
+// Retry-After header is set.
+func checkRateLimit(w http.ResponseWriter, r *http.Request,
+	endpoint string) time.Duration {
+	rl, ok := rateLimiters[endpoint]
+	if !ok {
+		return 0
+	}
+	ip := clientIP(r)
+	wait := rl.Take(ip, time.Now())
+	if wait > 0 {
+		log.Printf("Rate limit of %s exceeded by %s, retry after %v",
+			endpoint, ip, wait)
+		w.Header().Set("Retry-After",
+			fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+	}
+	return wait
+}
+
+func tooManyRequestsMessage(wait time.Duration) string {
+	return fmt.Sprintf("Too many requests, please retry after %v.",
+		SimpleDuration(wait))
+}
+
+func pageTooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.WriteHeader(http.StatusTooManyRequests)
+	if err := templates.ExecuteTemplate(w, "429.html",
+		tooManyRequestsMessage(wait)); err != nil {
+		w.Write([]byte(err.Error()))
+	}
+}
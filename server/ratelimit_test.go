@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/daviddengcn/gcse"
+	"github.com/daviddengcn/go-assert"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(nets []*net.IPNet) {
+		trustedProxies = nets
+	}(trustedProxies)
+	trustedProxies = parseTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16",
+		"invalid"})
+
+	for _, c := range []struct {
+		remote, forwarded, ip string
+	}{
+		// untrusted remote, X-Forwarded-For ignored
+		{"1.2.3.4:80", "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.2:80", "5.6.7.8", "10.0.0.2"},
+		// trusted remote
+		{"10.0.0.1:80", "", "10.0.0.1"},
+		{"10.0.0.1:80", "5.6.7.8", "5.6.7.8"},
+		// addresses to the left of an untrusted hop are spoofable
+		{"10.0.0.1:80", "9.9.9.9, 5.6.7.8", "5.6.7.8"},
+		{"10.0.0.1:80", "9.9.9.9, 5.6.7.8, 192.168.1.1", "5.6.7.8"},
+		// all hops trusted
+		{"10.0.0.1:80", "192.168.1.2, 192.168.1.1", "192.168.1.2"},
+		{"10.0.0.1", "5.6.7.8, ", "5.6.7.8"},
+	} {
+		r := &http.Request{RemoteAddr: c.remote, Header: http.Header{}}
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		assert.Equals(t, c.remote+" "+c.forwarded, clientIP(r), c.ip)
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter(gcse.RateLimit{Rate: 1, Burst: 2})
+	for _, c := range []struct {
+		client string
+		after  time.Duration
+		wait   time.Duration
+	}{
+		{"a", 0, 0},
+		{"a", 0, 0},
+		{"a", 0, time.Second},
+		{"b", 0, 0},
+		{"a", 500 * time.Millisecond, 500 * time.Millisecond},
+		{"a", 500 * time.Millisecond, 0},
+		{"a", 0, time.Second},
+		{"a", 10 * time.Second, 0},
+		{"a", 0, 0},
+		{"a", 0, time.Second},
+	} {
+		now = now.Add(c.after)
+		assert.Equals(t, c.client+" wait", rl.Take(c.client, now), c.wait)
+	}
+
+	assert.Equals(t, "unlimited", newRateLimiter(gcse.RateLimit{}).Take("a",
+		now), time.Duration(0))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+
+	"github.com/daviddengcn/gcse"
+)
+
+// the RankModel of gcse.ScorerModel, nil for the default scorer
+var rankModel *gcse.RankModel
+
+// loadScorer loads the RankModel if gcse.ScorerModel is configured. The
+// default scorer is used if it fails.
+func loadScorer() {
+	switch gcse.ServerScorer {
+	case gcse.ScorerDefault:
+		return
+	case gcse.ScorerModel:
+		m, err := gcse.LoadRankModel(gcse.ServerRankModel)
+		if err != nil {
+			log.Printf("Load rank model failed, using the default scorer: %v",
+				err)
+			return
+		}
+		rankModel = m
+		log.Printf("Rank model trained at %v from %d pairs loaded", m.Trained,
+			m.Pairs)
+	default:
+		log.Printf("Unknown scorer %q, using the default scorer",
+			gcse.ServerScorer)
+	}
+}
+
+func rankFeatures(hit *Hit) []float64 {
+	return gcse.RankFeatures(&hit.HitInfo, hit.Match, hit.MatchScore)
+}
+
+// scoreOfHit returns the score of a hit whose Match and MatchScore are set.
+func scoreOfHit(hit *Hit) float64 {
+	if rankModel != nil {
+		return rankModel.Score(rankFeatures(hit))
+	}
+	return maxF(hit.StaticScore, hit.TestStaticScore) * hit.MatchScore
+}
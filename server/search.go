@@ -1,10 +1,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"math"
+	"net/http"
 	"runtime"
+	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/daviddengcn/gcse"
@@ -14,6 +19,8 @@ import (
 
 type Hit struct {
 	gcse.HitInfo
+	DocID      int32
+	Match      gcse.MatchComponents
 	MatchScore float64
 	Score      float64
 }
@@ -21,6 +28,54 @@ type Hit struct {
 type SearchResult struct {
 	TotalResults int
 	Hits         []*Hit
+	// the index searched, for loading ReadmeData of hits
+	db indexSearcher
+}
+
+// indexSearcher is implemented by both *index.TokenSetSearcher, loaded from
+// IndexFn, and *gcse.MMIndex, mapped from MMIndexFn.
+type indexSearcher interface {
+	DocCount() int
+	TokenDocList(field, token string) []int32
+	Search(query map[string]villa.StrSet,
+		output func(docID int32, data interface{}) error) error
+}
+
+// readmeData returns the ReadmeData of a doc. An MMIndex does not return
+// ReadmeData in search results, it is read here only when needed.
+func readmeData(db indexSearcher, docID int32, hit *gcse.HitInfo) string {
+	if r, ok := db.(interface {
+		ReadmeData(docID int32) string
+	}); ok && hit.ReadmeData == "" {
+		return r.ReadmeData(docID)
+	}
+	return hit.ReadmeData
+}
+
+// examples returns the Examples of a doc. Like ReadmeData, they are not
+// returned in search results of an MMIndex.
+func examples(db indexSearcher, docID int32,
+	hit *gcse.HitInfo) []gcse.Example {
+	if e, ok := db.(interface {
+		Examples(docID int32) ([]gcse.Example, error)
+	}); ok && len(hit.Examples) == 0 {
+		exs, err := e.Examples(docID)
+		if err != nil {
+			log.Printf("Load examples of %s failed: %v", hit.Package, err)
+		}
+		return exs
+	}
+	return hit.Examples
+}
+
+// tokenDocCount returns the number of docs containing token in field.
+func tokenDocCount(db indexSearcher, field, token string) int {
+	if c, ok := db.(interface {
+		TokenDocCount(field, token string) int
+	}); ok {
+		return c.TokenDocCount(field, token)
+	}
+	return len(db.TokenDocList(field, token))
 }
 
 var stopWords = villa.NewStrSet(
@@ -31,57 +86,259 @@ var (
 	indexDBBox   villa.AtomicBox
 	indexSegment gcse.Segment
 	indexUpdated time.Time
+	// the time when the index of the previous segment was generated
+	indexPrevUpdated time.Time
 )
 
-func loadIndex() error {
-	segm, err := gcse.IndexSegments.FindMaxDone()
-	if segm == nil || err != nil {
-		return err
+// errIndexNotLoaded is returned by search if no index is loaded, e.g. when the
+// old index is released before loading the new one with IndexSwapRelease.
+var errIndexNotLoaded = errors.New("index is not loaded")
+
+// indexNotLoaded writes StatusServiceUnavailable for a request needing the
+// index.
+func indexNotLoaded(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "The index is being loaded, please retry later.",
+		http.StatusServiceUnavailable)
+}
+
+// searchError writes the error returned by search.
+func searchError(w http.ResponseWriter, err error) {
+	if err == errIndexNotLoaded {
+		indexNotLoaded(w)
+		return
 	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
 
-	if indexSegment != nil && !gcse.SegmentLess(indexSegment, segm) {
-		// no new index
-		return nil
+// loadIndexFile maps the MMIndex shards or the MMIndexFn of the segment if
+// they exist, otherwise loads the IndexFn.
+func loadIndexFile(segm gcse.Segment) (indexSearcher, error) {
+	if segm.Join(gcse.MMIndexShardFn(0)).Exists() {
+		db, err := loadIndexShards(segm)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+	if fn := segm.Join(gcse.MMIndexFn); fn.Exists() {
+		db, err := gcse.OpenMMIndex(fn)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
 	}
 
 	db := &index.TokenSetSearcher{}
 	f, err := segm.Join(gcse.IndexFn).Open()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close()
 
 	if err := db.Load(f); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// refCountedIndex is implemented by the indexes on mapped files, i.e.
+// *gcse.MMIndex and *shardedIndex, which are closed after the last use.
+type refCountedIndex interface {
+	Acquire() bool
+	Release()
+	Close() error
+}
+
+// acquireIndex returns the loaded index, nil if not loaded, and the function
+// to call when done with it. The index is not closed before that even if it
+// is replaced.
+func acquireIndex() (indexSearcher, func()) {
+	for {
+		db, _ := indexDBBox.Get().(indexSearcher)
+		rc, ok := db.(refCountedIndex)
+		if !ok {
+			return db, func() {}
+		}
+		if rc.Acquire() {
+			return db, rc.Release
+		}
+		// closed after being replaced, get the new one
+	}
+}
+
+// retireIndex closes an index removed from indexDBBox once the searches using
+// it finish.
+func retireIndex(db indexSearcher) {
+	if rc, ok := db.(refCountedIndex); ok {
+		if err := rc.Close(); err != nil {
+			log.Printf("Closing index failed: %v", err)
+		}
+	}
+}
+
+// releaseIndex reports not-ready, waits for draining and releases the
+// current index. Returns the segment of the released index, nil if no index
+// was loaded.
+func releaseIndex() gcse.Segment {
+	db, _ := indexDBBox.Get().(indexSearcher)
+	if db == nil {
+		return nil
+	}
+	setDraining(true)
+	defer setDraining(false)
+
+	log.Printf("Draining for %v before releasing index %v ...",
+		gcse.ServerDrainDuration, indexSegment)
+	time.Sleep(gcse.ServerDrainDuration)
+
+	indexDBBox.Set(nil)
+	retireIndex(db)
+	searchCache.Clear()
+	runtime.GC()
+	debug.FreeOSMemory()
+	gcse.DumpMemStats()
+
+	return indexSegment
+}
+
+var (
+	// serializes loadIndex of loadIndexLoop and /admin
+	loadIndexMu sync.Mutex
+	// the name of the last segment failed to load and the error, which is
+	// not retried until forced
+	failedSegment string
+	failedErr     error
+)
+
+// loadIndex loads the latest index segment if it is newer than the loaded
+// one, or if force is true. tried is true if a segment was loaded, and err is
+// the error of loading it, so that only real loads are counted in the
+// metrics.
+func loadIndex(force bool) (tried bool, err error) {
+	loadIndexMu.Lock()
+	defer loadIndexMu.Unlock()
+
+	segm, err := gcse.IndexSegments.FindMaxDone()
+	if segm == nil || err != nil {
+		return false, err
+	}
+
+	if !force && indexSegment != nil &&
+		!gcse.SegmentLess(indexSegment, segm) {
+		// no new index
+		return false, nil
+	}
+	if !force && segm.Name() == failedSegment {
+		// a broken segment, not to release the index again for it
+		return false, failedErr
+	}
+	err = loadIndexSegment(segm)
+	if err != nil {
+		failedSegment, failedErr = segm.Name(), err
+	} else {
+		failedSegment, failedErr = "", nil
+	}
+	return true, err
+}
+
+// loadIndexSegment loads the index of segm and replaces the loaded one.
+func loadIndexSegment(segm gcse.Segment) error {
+	indexFn := segm.Join(gcse.MMIndexShardFn(0))
+	if !indexFn.Exists() {
+		indexFn = segm.Join(gcse.MMIndexFn)
+	}
+	if !indexFn.Exists() {
+		indexFn = segm.Join(gcse.IndexFn)
+	}
+	if !indexFn.Exists() {
+		return fmt.Errorf("index file not found in %v", segm)
+	}
+
+	var released gcse.Segment
+	if gcse.ServerIndexSwap == gcse.IndexSwapRelease {
+		released = releaseIndex()
+	}
+
+	db, err := loadIndexFile(segm)
+	if err != nil {
+		if released != nil {
+			// try restoring the released index
+			log.Printf("Load index from %v failed: %v, restoring %v", segm,
+				err, released)
+			if db, errOld := loadIndexFile(released); errOld == nil {
+				indexDBBox.Set(db)
+			} else {
+				log.Printf("Restore index from %v failed: %v", released,
+					errOld)
+			}
+		}
 		return err
 	}
 
 	indexSegment = segm
 	log.Printf("Load index from %v (%d packages)", segm, db.DocCount())
 
+	old, _ := indexDBBox.Get().(indexSearcher)
 	indexDBBox.Set(db)
+	retireIndex(old)
+	searchCache.Clear()
 	updateTime := time.Now()
 
-	if st, err := segm.Join(gcse.IndexFn).Stat(); err == nil {
+	if st, err := indexFn.Stat(); err == nil {
 		updateTime = st.ModTime()
 	}
 
 	indexUpdated = updateTime
 
+	var info gcse.IndexInfo
+	if err := gcse.ReadJsonFile(segm.Join(gcse.IndexInfoFn), &info); err != nil {
+		log.Printf("Read index info of %v failed: %v", segm, err)
+	}
+	indexPrevUpdated = info.PrevUpdated
+
 	db = nil
 	gcse.DumpMemStats()
 	runtime.GC()
+	debug.FreeOSMemory()
 	gcse.DumpMemStats()
 
 	return nil
 }
 
+// the ban list of the crawler, reloaded when modified, e.g. by tools/ban
+var (
+	banDBCache  = newMemDBCache(gcse.KindBan)
+	loadedBanDB *gcse.MemDB
+)
+
+// reloadBanList loads the ban list for gcse.IsBanned if it is modified since
+// last loaded. Cached pages are invalidated since they may contain packages
+// banned since.
+func reloadBanList() {
+	db := banDBCache.Get().(*gcse.MemDB)
+	if db == loadedBanDB {
+		return
+	}
+	gcse.LoadBanList(db)
+	if loadedBanDB != nil {
+		banListChanged()
+	}
+	loadedBanDB = db
+}
+
 func loadIndexLoop() {
 	for {
-		time.Sleep(30 * time.Second)
-
-		if err := loadIndex(); err != nil {
+		reloadBanList()
+		tried, err := loadIndex(false)
+		if tried {
+			metrics.ObserveIndexLoad(err)
+		}
+		if err != nil {
 			log.Printf("loadIndex failed: %v", err)
 		}
+
+		time.Sleep(30 * time.Second)
 	}
 }
 
@@ -103,87 +360,178 @@ func idf(df, N int) float64 {
 	return idf
 }
 
-func search(q string) (*SearchResult, villa.StrSet, error) {
-	tokens := gcse.AppendTokens(nil, []byte(q))
-	tokenList := tokens.Elements()
-	log.Printf("tokens for query %s: %v", q, tokens)
-
-	indexDB := indexDBBox.Get().(*index.TokenSetSearcher)
-
-	if indexDB == nil {
-		return &SearchResult{}, tokens, nil
+// hitLess returns true if hit a is ranked before hit b.
+func hitLess(a, b *Hit) bool {
+	ssa, ssb := a.Score, b.Score
+	if ssa > ssb {
+		return true
+	}
+	if ssa < ssb {
+		return false
 	}
 
-	var hits []*Hit
-
-	N := indexDB.DocCount()
-	TextDf := func(token string) int {
-		return len(indexDB.TokenDocList(gcse.IndexTextField, token))
+	sca, scb := a.StarCount, b.StarCount
+	if sca > scb {
+		return true
 	}
-	NameDf := func(token string) int {
-		return len(indexDB.TokenDocList(gcse.IndexNameField, token))
+	if sca < scb {
+		return false
 	}
 
-	textIdfs := make([]float64, len(tokenList))
-	nameIdfs := make([]float64, len(tokenList))
-	for i := range textIdfs {
-		textIdfs[i] = idf(TextDf(tokenList[i]), N)
-		nameIdfs[i] = idf(NameDf(tokenList[i]), N)
+	pa, pb := a.Package, b.Package
+	if len(pa) < len(pb) {
+		return true
 	}
+	if len(pa) > len(pb) {
+		return false
+	}
+
+	return pa < pb
+}
 
-	indexDB.Search(map[string]villa.StrSet{gcse.IndexTextField: tokens},
+// searchShard returns the sorted hits in a shard whose docIDs start from base.
+func searchShard(db indexSearcher, base int32,
+	query map[string]villa.StrSet, tokenList []string,
+	textIdfs, nameIdfs []float64) ([]*Hit, error) {
+	var hits []*Hit
+	if err := db.Search(query,
 		func(docID int32, data interface{}) error {
 			hitInfo, _ := data.(gcse.HitInfo)
+			if gcse.IsBanned(hitInfo.Package) {
+				// banned after the index was built
+				return nil
+			}
 			hit := &Hit{
 				HitInfo: hitInfo,
+				DocID:   base + docID,
 			}
 
-			hit.MatchScore = gcse.CalcMatchScore(&hitInfo, tokenList,
+			hit.Match = gcse.CalcMatchComponents(&hitInfo, tokenList,
 				textIdfs, nameIdfs)
-			hit.Score = maxF(hit.StaticScore, hit.TestStaticScore) *
-				hit.MatchScore
+			hit.MatchScore = gcse.MatchScoreOf(hit.Match, len(tokenList))
+			hit.Score = scoreOfHit(hit)
 
 			hits = append(hits, hit)
 			return nil
-		})
-
-	log.Printf("Got %d hits for query %q", len(hits), q)
+		}); err != nil {
+		return nil, err
+	}
 
 	villa.SortF(len(hits), func(i, j int) bool {
 		// true if doc i is before doc j
-		ssi, ssj := hits[i].Score, hits[j].Score
-		if ssi > ssj {
-			return true
-		}
-		if ssi < ssj {
-			return false
-		}
+		return hitLess(hits[i], hits[j])
+	}, func(i, j int) {
+		// Swap
+		hits[i], hits[j] = hits[j], hits[i]
+	})
+	return hits, nil
+}
+
+type queryQualifier struct {
+	// the index field to filter on
+	field string
+	// whether values are lower-cased before matching
+	lower bool
+}
+
+// query qualifiers and the index fields they filter on
+var queryQualifiers = map[string]queryQualifier{
+	"sym:":     {field: gcse.IndexSymbolField},
+	"license:": {field: gcse.IndexLicenseField, lower: true},
+}
 
-		sci, scj := hits[i].StarCount, hits[j].StarCount
-		if sci > scj {
-			return true
+// parseQuery separates the words with a qualifier, e.g. "sym:NewClient", from
+// the text of a query. Values of qualifiers are matched exactly, after being
+// lower-cased if the qualifier is case-insensitive.
+func parseQuery(q string) (text string, fields map[string]villa.StrSet) {
+	var words []string
+	for _, word := range strings.Fields(q) {
+		qualified := false
+		for prefix, qual := range queryQualifiers {
+			if strings.HasPrefix(word, prefix) {
+				if value := word[len(prefix):]; value != "" {
+					if qual.lower {
+						value = strings.ToLower(value)
+					}
+					if fields == nil {
+						fields = make(map[string]villa.StrSet)
+					}
+					set := fields[qual.field]
+					set.Put(value)
+					fields[qual.field] = set
+				}
+				qualified = true
+				break
+			}
 		}
-		if sci < scj {
-			return false
+		if !qualified {
+			words = append(words, word)
 		}
+	}
+	return strings.Join(words, " "), fields
+}
 
-		pi, pj := hits[i].Package, hits[j].Package
-		if len(pi) < len(pj) {
-			return true
-		}
-		if len(pi) > len(pj) {
-			return false
+// search searches q in indexDB, acquired by acquireIndex. At most limit top
+// hits are returned, all if limit is not positive, while TotalResults is the
+// number of all hits.
+func search(indexDB indexSearcher, q string, limit int) (*SearchResult,
+	villa.StrSet, error) {
+	text, fields := parseQuery(q)
+	tokens := gcse.AppendTokens(nil, []byte(text))
+	tokenList := tokens.Elements()
+	log.Printf("tokens for query %s: %v, fields: %v", q, tokens, fields)
+
+	query := map[string]villa.StrSet{gcse.IndexTextField: tokens}
+	for field, values := range fields {
+		query[field] = values
+	}
+
+	if indexDB == nil {
+		return nil, tokens, errIndexNotLoaded
+	}
+
+	N := indexDB.DocCount()
+	textIdfs := make([]float64, len(tokenList))
+	nameIdfs := make([]float64, len(tokenList))
+	for i := range textIdfs {
+		textIdfs[i] = idf(tokenDocCount(indexDB, gcse.IndexTextField,
+			tokenList[i]), N)
+		nameIdfs[i] = idf(tokenDocCount(indexDB, gcse.IndexNameField,
+			tokenList[i]), N)
+	}
+
+	// scatter the query to all shards and gather sorted hits
+	shards, bases := searchShards(indexDB)
+	shardHits := make([][]*Hit, len(shards))
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i := range shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shardHits[i], errs[i] = searchShard(shards[i], bases[i], query,
+				tokenList, textIdfs, nameIdfs)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, tokens, err
 		}
+	}
+	total := 0
+	for _, l := range shardHits {
+		total += len(l)
+	}
+	hits := mergeHits(shardHits, limit)
 
-		return pi < pj
-	}, func(i, j int) {
-		// Swap
-		hits[i], hits[j] = hits[j], hits[i]
-	})
+	log.Printf("Got %d hits for query %q", total, q)
+	metrics.ObserveSearch(total)
 
 	return &SearchResult{
-		TotalResults: len(hits),
+		TotalResults: total,
 		Hits:         hits,
+		db:           indexDB,
 	}, tokens, nil
 }
 
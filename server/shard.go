@@ -0,0 +1,169 @@
+package main
+
+import (
+	"github.com/daviddengcn/gcse"
+	"github.com/daviddengcn/go-villa"
+)
+
+// shardedIndex is the MMIndex shards of a segment as a single indexSearcher.
+// The docID of a doc is the docID in its shard plus the number of docs in
+// previous shards.
+type shardedIndex struct {
+	shards []*gcse.MMIndex
+	bases  []int32
+	count  int
+}
+
+// loadIndexShards maps all MMIndexShardFn files of the segment.
+func loadIndexShards(segm gcse.Segment) (*shardedIndex, error) {
+	idx := &shardedIndex{}
+	for i := 0; ; i++ {
+		fn := segm.Join(gcse.MMIndexShardFn(i))
+		if !fn.Exists() {
+			break
+		}
+		shard, err := gcse.OpenMMIndex(fn)
+		if err != nil {
+			idx.Close()
+			return nil, err
+		}
+		idx.shards = append(idx.shards, shard)
+		idx.bases = append(idx.bases, int32(idx.count))
+		idx.count += shard.DocCount()
+	}
+	return idx, nil
+}
+
+// Acquire acquires all shards. Returns false if they are closed.
+func (idx *shardedIndex) Acquire() bool {
+	for i, shard := range idx.shards {
+		if !shard.Acquire() {
+			for _, acquired := range idx.shards[:i] {
+				acquired.Release()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *shardedIndex) Release() {
+	for _, shard := range idx.shards {
+		shard.Release()
+	}
+}
+
+// Close closes all shards and returns the first error.
+func (idx *shardedIndex) Close() (err error) {
+	for _, shard := range idx.shards {
+		if errClose := shard.Close(); errClose != nil && err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+func (idx *shardedIndex) DocCount() int {
+	return idx.count
+}
+
+func (idx *shardedIndex) TokenDocCount(field, token string) int {
+	cnt := 0
+	for _, shard := range idx.shards {
+		cnt += shard.TokenDocCount(field, token)
+	}
+	return cnt
+}
+
+func (idx *shardedIndex) TokenDocList(field, token string) []int32 {
+	var docIDs []int32
+	for i, shard := range idx.shards {
+		for _, docID := range shard.TokenDocList(field, token) {
+			docIDs = append(docIDs, idx.bases[i]+docID)
+		}
+	}
+	return docIDs
+}
+
+// Search searches the shards one by one, so the docs are output in the order
+// of docIDs. search() searches shards in parallel.
+func (idx *shardedIndex) Search(query map[string]villa.StrSet,
+	output func(docID int32, data interface{}) error) error {
+	for i, shard := range idx.shards {
+		base := idx.bases[i]
+		if err := shard.Search(query, func(docID int32,
+			data interface{}) error {
+			return output(base+docID, data)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shard returns the shard of a docID and the docID in the shard.
+func (idx *shardedIndex) shard(docID int32) (*gcse.MMIndex, int32) {
+	for i := len(idx.shards) - 1; i >= 0; i-- {
+		if docID >= idx.bases[i] {
+			return idx.shards[i], docID - idx.bases[i]
+		}
+	}
+	return nil, 0
+}
+
+func (idx *shardedIndex) ReadmeData(docID int32) string {
+	shard, docID := idx.shard(docID)
+	if shard == nil {
+		return ""
+	}
+	return shard.ReadmeData(docID)
+}
+
+func (idx *shardedIndex) Examples(docID int32) ([]gcse.Example, error) {
+	shard, docID := idx.shard(docID)
+	if shard == nil {
+		return nil, nil
+	}
+	return shard.Examples(docID)
+}
+
+// searchShards returns the shards of db to be searched in parallel along with
+// the base docIDs of them.
+func searchShards(db indexSearcher) ([]indexSearcher, []int32) {
+	sharded, ok := db.(*shardedIndex)
+	if !ok {
+		return []indexSearcher{db}, []int32{0}
+	}
+	shards := make([]indexSearcher, len(sharded.shards))
+	for i, shard := range sharded.shards {
+		shards[i] = shard
+	}
+	return shards, sharded.bases
+}
+
+// mergeHits merges lists of hits, each sorted by hitLess, into one sorted
+// list of the top limit hits, or all hits if limit is not positive.
+func mergeHits(lists [][]*Hit, limit int) []*Hit {
+	total := 0
+	for _, l := range lists {
+		total += len(l)
+	}
+	if limit > 0 && total > limit {
+		total = limit
+	}
+	if len(lists) == 1 {
+		return lists[0][:total]
+	}
+	hits := make([]*Hit, 0, total)
+	for len(hits) < total {
+		best := -1
+		for i, l := range lists {
+			if len(l) > 0 && (best < 0 || hitLess(l[0], lists[best][0])) {
+				best = i
+			}
+		}
+		hits = append(hits, lists[best][0])
+		lists[best] = lists[best][1:]
+	}
+	return hits
+}
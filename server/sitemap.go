@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/daviddengcn/gcse"
+)
+
+// maximum number of URLs in a sitemap page, the protocol allows up to 50000
+const sitemapPageSize = 10000
+
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	NS       string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+func writeXML(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(v)
+}
+
+func viewURL(base, pkg string) string {
+	return base + "/view?" + url.Values{"id": {pkg}}.Encode()
+}
+
+// pageSitemap returns the sitemap index if no page is specified, otherwise
+// the p-th (zero-based) page of package URLs.
+func pageSitemap(w http.ResponseWriter, r *http.Request) {
+	base := "http://" + r.Host
+	indexDB, release := acquireIndex()
+	defer release()
+	docCount := 0
+	if indexDB != nil {
+		docCount = indexDB.DocCount()
+	}
+
+	pStr := r.FormValue("p")
+	if pStr == "" {
+		sm := sitemapIndex{NS: sitemapNS}
+		lastMod := ""
+		if !indexUpdated.IsZero() {
+			lastMod = indexUpdated.UTC().Format("2006-01-02")
+		}
+		for p := 0; p*sitemapPageSize < docCount; p++ {
+			sm.Sitemaps = append(sm.Sitemaps, sitemapURL{
+				Loc:     fmt.Sprintf("%s/sitemap.xml?p=%d", base, p),
+				LastMod: lastMod,
+			})
+		}
+		writeXML(w, "application/xml; charset=utf-8", sm)
+		return
+	}
+
+	p, err := strconv.Atoi(pStr)
+	if err != nil || p < 0 || p*sitemapPageSize >= docCount {
+		http.Error(w, fmt.Sprintf("Sitemap page %s not found!", pStr),
+			http.StatusNotFound)
+		return
+	}
+
+	start, end := p*sitemapPageSize, (p+1)*sitemapPageSize
+	urlSet := sitemapURLSet{
+		NS:   sitemapNS,
+		URLs: make([]sitemapURL, 0, sitemapPageSize),
+	}
+	idx := 0
+	indexDB.Search(nil, func(docID int32, data interface{}) error {
+		if hit := data.(gcse.HitInfo); idx >= start && idx < end &&
+			!gcse.IsBanned(hit.Package) {
+			u := sitemapURL{
+				Loc: viewURL(base, hit.Package),
+			}
+			if !hit.LastUpdated.IsZero() {
+				u.LastMod = hit.LastUpdated.UTC().Format("2006-01-02")
+			}
+			urlSet.URLs = append(urlSet.URLs, u)
+		}
+		idx++
+		return nil
+	})
+	writeXML(w, "application/xml; charset=utf-8", urlSet)
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Method   string `xml:"method,attr,omitempty"`
+	Template string `xml:"template,attr"`
+}
+
+type openSearchImage struct {
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+	Type   string `xml:"type,attr"`
+	URL    string `xml:",chardata"`
+}
+
+type openSearchDescription struct {
+	XMLName       xml.Name        `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName     string          `xml:"ShortName"`
+	Description   string          `xml:"Description"`
+	InputEncoding string          `xml:"InputEncoding"`
+	Image         openSearchImage `xml:"Image"`
+	URLs          []openSearchURL `xml:"Url"`
+}
+
+// pageOpenSearch returns the OpenSearch description document so that
+// browsers can add Go Search as a search engine.
+func pageOpenSearch(w http.ResponseWriter, r *http.Request) {
+	base := "http://" + r.Host
+	writeXML(w, "application/opensearchdescription+xml; charset=utf-8",
+		openSearchDescription{
+			ShortName:     "Go Search",
+			Description:   "Find popular and relevant Go packages",
+			InputEncoding: "UTF-8",
+			Image: openSearchImage{
+				Width:  16,
+				Height: 16,
+				Type:   "image/png",
+				URL:    base + "/images/logo-16.png",
+			},
+			URLs: []openSearchURL{
+				{
+					Type:     "text/html",
+					Method:   "get",
+					Template: base + "/search?q={searchTerms}",
+				}, {
+					Type:     "application/x-suggestions+json",
+					Template: base + "/suggest?q={searchTerms}",
+				}, {
+					Type:     "application/opensearchdescription+xml",
+					Template: base + "/opensearch.xml",
+				},
+			},
+		})
+}
+
+const maxSuggestions = 10
+
+// pageSuggest returns search suggestions in the format of
+// application/x-suggestions+json, i.e. an array of the query, completions,
+// descriptions and URLs.
+func pageSuggest(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.FormValue("q"))
+	completions, descs, urls := []string{}, []string{}, []string{}
+	if q != "" {
+		indexDB, release := acquireIndex()
+		defer release()
+		results, _, err := search(indexDB, q, maxSuggestions)
+		if err != nil {
+			searchError(w, err)
+			return
+		}
+		base := "http://" + r.Host
+		for _, hit := range results.Hits {
+			if len(completions) >= maxSuggestions {
+				break
+			}
+			completions = append(completions, hit.Package)
+			descs = append(descs, hit.Synopsis)
+			urls = append(urls, viewURL(base, hit.Package))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-suggestions+json; charset=utf-8")
+	w.Write(JSon([]interface{}{q, completions, descs, urls}))
+}
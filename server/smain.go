@@ -4,13 +4,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	godoc "go/doc"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/daviddengcn/gcse"
@@ -27,12 +31,16 @@ func Markdown(templ string) template.HTML {
 	return template.HTML(blackfriday.MarkdownCommon(out))
 }
 
-func init() {
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
-
+// loadTemplates parses the templates under gcse.ServerRoot. It is called by
+// main rather than init so that tests of the package run without them.
+func loadTemplates() {
 	templates = template.Must(template.New("templates").Funcs(template.FuncMap{
 		"markdown": Markdown,
 	}).ParseGlob(gcse.ServerRoot.Join(`web/*`).S()))
+}
+
+func init() {
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
 	http.Handle("/css/", http.StripPrefix("/css/",
 		http.FileServer(http.Dir(gcse.ServerRoot.Join("css").S()))))
@@ -44,12 +52,25 @@ func init() {
 		gcse.ServerRoot.Join("static").S())))
 
 	http.HandleFunc("/add", pageAdd)
+	http.HandleFunc("/submission", pageSubmission)
 	http.HandleFunc("/search", pageSearch)
+	http.HandleFunc("/click", pageClick)
 	http.HandleFunc("/view", pageView)
 	http.HandleFunc("/tops", pageTops)
 	http.HandleFunc("/about", staticPage("about.html"))
 	http.HandleFunc("/infoapi", staticPage("infoapi.html"))
 	http.HandleFunc("/api", pageApi)
+	http.HandleFunc("/feed", pageFeed)
+	http.HandleFunc("/feed/new", pageFeedNew)
+	http.HandleFunc("/badge", pageBadge)
+	http.HandleFunc("/sitemap.xml", pageSitemap)
+	http.HandleFunc("/opensearch.xml", pageOpenSearch)
+	http.HandleFunc("/suggest", pageSuggest)
+	http.HandleFunc("/metrics", pageMetrics)
+	http.HandleFunc("/healthz", pageHealthz)
+	http.HandleFunc("/readyz", pageReadyz)
+	http.HandleFunc("/admin", pageAdmin)
+	http.HandleFunc("/admin/ban", pageAdminBan)
 
 	//	http.HandleFunc("/update", pageUpdate)
 
@@ -60,23 +81,71 @@ type LogHandler struct{}
 
 func (hdl LogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[B] %s %v %s %v", r.Method, r.RequestURI, r.RemoteAddr, r.Header.Get("X-Forwarded-For"))
-	http.DefaultServeMux.ServeHTTP(w, r)
+	startTime := time.Now()
+	sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	sw.Header().Add("Vary", "Accept-Encoding")
+	if acceptsGzip(r) && r.Header.Get("Range") == "" {
+		gw := &gzipResponseWriter{ResponseWriter: sw}
+		http.DefaultServeMux.ServeHTTP(gw, r)
+		if err := gw.Close(); err != nil {
+			log.Printf("Closing gzip writer failed: %v", err)
+		}
+	} else {
+		http.DefaultServeMux.ServeHTTP(sw, r)
+	}
+	_, pattern := http.DefaultServeMux.Handler(r)
+	metrics.ObserveRequest(pattern, sw.status, time.Since(startTime))
 	log.Printf("[E] %s %v %s %v", r.Method, r.RequestURI, r.RemoteAddr, r.Header.Get("X-Forwarded-For"))
 }
 
 func main() {
+	loadTemplates()
 	if err := gcse.ImportSegments.ClearUndones(); err != nil {
 		log.Printf("CleanImportSegments failed: %v", err)
 	}
+	// banned packages are rejected at /add and hidden from the results
+	reloadBanList()
+	// projects of packages under vanity import paths, as in the indexer
+	gcse.LoadVanityRepos(gcse.NewMemDB(gcse.CrawlerDBPath, gcse.KindVanity))
+	startQueryLog()
+	loadScorer()
 
-	if err := loadIndex(); err != nil {
-		log.Fatal(err)
-	}
+	// the index is loaded in background, /readyz reports not-ready before
+	// it is loaded
 	go loadIndexLoop()
 
-	log.Printf("ListenAndServe at %s ...", gcse.ServerAddr)
+	server := &http.Server{
+		Addr:    gcse.ServerAddr,
+		Handler: LogHandler{},
+	}
+	stopped := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		sig := <-sigs
+		log.Printf("Signal %v received, draining for %v ...", sig,
+			gcse.ServerDrainDuration)
+		setDraining(true)
+		time.Sleep(gcse.ServerDrainDuration)
+
+		log.Printf("Shutting down, waiting at most %v for requests ...",
+			gcse.ServerShutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(),
+			gcse.ServerShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("server.Shutdown failed: %v", err)
+		}
+		close(stopped)
+	}()
 
-	http.ListenAndServe(gcse.ServerAddr, LogHandler{})
+	log.Printf("ListenAndServe at %s ...", gcse.ServerAddr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("ListenAndServe failed: %v", err)
+	}
+	<-stopped
+	stopQueryLog()
+	log.Printf("Server stopped")
 }
 
 type SimpleDuration time.Duration
@@ -119,7 +188,7 @@ func pageRoot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	docCount := 0
-	indexDB, _ := indexDBBox.Get().(*index.TokenSetSearcher)
+	indexDB, _ := indexDBBox.Get().(indexSearcher)
 	if indexDB != nil {
 		docCount = indexDB.DocCount()
 	}
@@ -146,15 +215,55 @@ func staticPage(tempName string) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+// addResult is the result of a submission at /add.
+type addResult struct {
+	// ID of the submission, "" if nothing accepted
+	ID       string `json:",omitempty"`
+	Accepted []string
+	Rejected []gcse.RejectedPackage
+}
+
+// pageAdd accepts packages submitted by the pkg parameter. The result is
+// returned in JSON if format=json.
 func pageAdd(w http.ResponseWriter, r *http.Request) {
 	pkgsStr := r.FormValue("pkg")
-	if pkgsStr != "" {
-		pkgs := strings.Split(pkgsStr, "\n")
-		log.Printf("%d packaged submitted!", len(pkgs))
-		gcse.AppendPackages(pkgs)
+	isJSON := r.FormValue("format") == "json"
+	var res *addResult
+	if strings.TrimSpace(pkgsStr) != "" {
+		if wait := checkRateLimit(w, r, "add"); wait > 0 {
+			if isJSON {
+				ApiContent(w, http.StatusTooManyRequests,
+					tooManyRequestsMessage(wait), "")
+			} else {
+				pageTooManyRequests(w, wait)
+			}
+			return
+		}
+		res = &addResult{}
+		res.Accepted, res.Rejected = gcse.NormalizeSubmission(pkgsStr)
+		log.Printf("%d packaged submitted, %d rejected!", len(res.Accepted),
+			len(res.Rejected))
+		if len(res.Accepted) > 0 {
+			sub, err := gcse.NewSubmission(res.Accepted)
+			if err != nil {
+				log.Printf("NewSubmission failed: %v", err)
+				http.Error(w, "Submitting failed, please try again later.",
+					http.StatusInternalServerError)
+				return
+			}
+			res.ID = sub.ID
+		}
 	}
 
-	err := templates.ExecuteTemplate(w, "add.html", nil)
+	if isJSON {
+		code := http.StatusOK
+		if res == nil || len(res.Accepted) == 0 {
+			code = http.StatusBadRequest
+		}
+		ApiContent(w, code, res, "")
+		return
+	}
+	err := templates.ExecuteTemplate(w, "add.html", res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -174,6 +283,8 @@ type ShowDocInfo struct {
 	MarkedName    template.HTML
 	MarkedPackage template.HTML
 	Subs          []SubProjectInfo
+	// link to the package logging the click, set by pageSearch
+	ClickURL string
 }
 
 type ShowResults struct {
@@ -273,7 +384,8 @@ mainLoop:
 		projToIdx[d.Package] = cnt
 		if r.In(cnt) {
 			markedName := markText(d.Name, tokens, markWord)
-			readme := gcse.ReadmeToText(d.ReadmeFn, d.ReadmeData)
+			readme := gcse.ReadmeToText(d.ReadmeFn,
+				readmeData(results.db, d.DocID, &d.HitInfo))
 			if len(readme) > 20*1024 {
 				readme = readme[:20*1024]
 			}
@@ -318,14 +430,45 @@ func pageSearch(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 
 	q := strings.TrimSpace(r.FormValue("q"))
-	results, tokens, err := search(q)
+	// the search is logged even if not modified
+	notModified := checkIndexCache(w, r, 0)
+	indexDB, release := acquireIndex()
+	defer release()
+	if indexDB == nil {
+		// neither served from nor stored into the cache
+		indexNotLoaded(w)
+		return
+	}
+	cacheKey := searchCacheKey(q, p)
+	if cached, ok := searchCache.Get(cacheKey); ok {
+		log.Printf("Search results of %q (page %d) found in cache", q, p)
+		page := cached.(*cachedSearch)
+		if notModified {
+			w.WriteHeader(http.StatusNotModified)
+		} else {
+			w.Write(page.Page)
+		}
+		entry := page.Log
+		entry.Latency = time.Since(startTime).Seconds() * 1000
+		entry.Cached = true
+		logQuery(&entry)
+		return
+	}
+
+	// all hits are needed to fold packages into their parents and count the
+	// entries, only the ones on the page are rendered in detail
+	results, tokens, err := search(indexDB, q, 0)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		searchError(w, err)
 		return
 	}
 
 	showResults := showSearchResults(results, tokens,
 		Range{(p - 1) * itemsPerPage, itemsPerPage})
+	for i := range showResults.Docs {
+		d := &showResults.Docs[i]
+		d.ClickURL = clickURL(q, p, d.Index, d.Package)
+	}
 	totalPages := (showResults.TotalEntries + itemsPerPage - 1) / itemsPerPage
 	log.Printf("totalPages: %d", totalPages)
 	var beforePages, afterPages []int
@@ -369,21 +512,51 @@ func pageSearch(w http.ResponseWriter, r *http.Request) {
 		TotalPages:  totalPages,
 	}
 	log.Printf("Search results ready")
-	err = templates.ExecuteTemplate(w, "search.html", data)
-	if err != nil {
+	var page villa.ByteSlice
+	if err := templates.ExecuteTemplate(&page, "search.html", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entry := gcse.QueryLogEntry{
+		Type:   gcse.QueryLogSearch,
+		Query:  q,
+		Page:   p,
+		Tokens: tokens.Elements(),
+		Hits:   showResults.TotalResults,
+		Shown:  len(showResults.Docs),
+	}
+	if entry.Shown > 0 {
+		entry.First = showResults.Docs[0].Index
+	}
+	for _, d := range showResults.Docs {
+		entry.Results = append(entry.Results, gcse.QueryLogResult{
+			Package:  d.Package,
+			Features: rankFeatures(d.Hit),
+		})
+	}
+	searchCache.Put(cacheKey, &cachedSearch{Page: page, Log: entry})
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+	} else {
+		w.Write(page)
 	}
 	log.Printf("Search results rendered")
+
+	entry.Latency = time.Since(startTime).Seconds() * 1000
+	logQuery(&entry)
 }
 
 func findPackage(id string, doc *gcse.HitInfo) (found bool) {
-	indexDB, _ := indexDBBox.Get().(*index.TokenSetSearcher)
-	if indexDB == nil {
+	indexDB, release := acquireIndex()
+	defer release()
+	if indexDB == nil || gcse.IsBanned(id) {
 		return false
 	}
 	indexDB.Search(index.SingleFieldQuery("pkg", id),
 		func(docID int32, data interface{}) error {
 			*doc, _ = data.(gcse.HitInfo)
+			doc.ReadmeData = readmeData(indexDB, docID, doc)
+			doc.Examples = examples(indexDB, docID, doc)
 			found = true
 			return nil
 		})
@@ -393,12 +566,19 @@ func findPackage(id string, doc *gcse.HitInfo) (found bool) {
 func pageView(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimSpace(r.FormValue("id"))
 	if id != "" {
+		if setIndexCacheHeaders(w, r, 0) {
+			return
+		}
+		if indexDBBox.Get() == nil {
+			indexNotLoaded(w)
+			return
+		}
 		var doc gcse.HitInfo
 		if !findPackage(id, &doc) {
 			http.Error(w, fmt.Sprintf("Package %s not found!", id), http.StatusNotFound)
 			return
 		}
-		indexDB, _ := indexDBBox.Get().(*index.TokenSetSearcher)
+		indexDB, _ := indexDBBox.Get().(indexSearcher)
 		if doc.StarCount < 0 {
 			doc.StarCount = 0
 		}
@@ -479,6 +659,25 @@ func pageApi(w http.ResponseWriter, r *http.Request) {
 		}
 		return true
 	})
+	if wait := checkRateLimit(w, r, "api"); wait > 0 {
+		ApiContent(w, http.StatusTooManyRequests, tooManyRequestsMessage(wait),
+			callback)
+		return
+	}
+	if action == "submission" {
+		// the status changes without the index updated, not cached
+		apiSubmission(w, r, callback)
+		return
+	}
+	if setIndexCacheHeaders(w, r, 0) {
+		return
+	}
+	if indexDBBox.Get() == nil {
+		w.Header().Set("Retry-After", "30")
+		ApiContent(w, http.StatusServiceUnavailable,
+			"The index is being loaded, please retry later.", callback)
+		return
+	}
 	switch action {
 	case "package":
 		id := r.FormValue("id")
@@ -500,6 +699,8 @@ func pageApi(w http.ResponseWriter, r *http.Request) {
 			Imports     []string
 			ProjectURL  string
 			StaticRank  int
+			Examples    []gcse.Example
+			License     string
 		}{
 			doc.Package,
 			doc.Name,
@@ -510,6 +711,8 @@ func pageApi(w http.ResponseWriter, r *http.Request) {
 			doc.Imports,
 			doc.ProjectURL,
 			doc.StaticRank + 1,
+			doc.Examples,
+			doc.License,
 		}, callback)
 
 	case "tops":
@@ -522,13 +725,21 @@ func pageApi(w http.ResponseWriter, r *http.Request) {
 		ApiContent(w, http.StatusOK, statTops(N), callback)
 
 	case "packages":
-		indexDB := indexDBBox.Get().(*index.TokenSetSearcher)
+		if wait := checkRateLimit(w, r, "packages"); wait > 0 {
+			ApiContent(w, http.StatusTooManyRequests,
+				tooManyRequestsMessage(wait), callback)
+			return
+		}
+		indexDB, release := acquireIndex()
+		defer release()
 		var pkgs []string
 		if indexDB != nil {
 			pkgs = make([]string, 0, indexDB.DocCount())
 			indexDB.Search(nil, func(docID int32, data interface{}) error {
 				doc := data.(gcse.HitInfo)
-				pkgs = append(pkgs, doc.Package)
+				if !gcse.IsBanned(doc.Package) {
+					pkgs = append(pkgs, doc.Package)
+				}
 
 				return nil
 			})
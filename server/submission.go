@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/daviddengcn/gcse"
+	"github.com/daviddengcn/gddo/doc"
+	"github.com/daviddengcn/go-index"
+)
+
+// packageStatus is the status of a package in a submission.
+type packageStatus struct {
+	Package string
+	// one of gcse.Submit* states
+	State  string
+	Reason string `json:",omitempty"`
+	// time the state was recorded by the crawler, zero if not yet
+	Updated time.Time
+}
+
+type submissionStatus struct {
+	ID       string
+	Time     time.Time
+	Packages []packageStatus
+}
+
+// inIndex returns whether a package is in the loaded index.
+func inIndex(pkg string) (found bool) {
+	indexDB, release := acquireIndex()
+	defer release()
+	if indexDB == nil {
+		return false
+	}
+	indexDB.Search(index.SingleFieldQuery("pkg", pkg),
+		func(docID int32, data interface{}) error {
+			found = true
+			return nil
+		})
+	return found
+}
+
+// the states of submitted packages recorded by the crawler
+var submitDBCache = newMemDBCache(gcse.KindSubmit)
+
+// statusOfSubmission returns the status of the packages of a submission by
+// the states recorded by the crawler and the loaded index.
+func statusOfSubmission(sub *gcse.Submission) *submissionStatus {
+	submitDB := submitDBCache.Get().(*gcse.MemDB)
+	status := &submissionStatus{
+		ID:   sub.ID,
+		Time: sub.Time,
+	}
+	for _, pkg := range sub.Packages {
+		pkg = strings.TrimSpace(pkg)
+		if pkg == "" {
+			continue
+		}
+		ps := packageStatus{
+			Package: pkg,
+			State:   gcse.SubmitQueued,
+		}
+		var st gcse.SubmitState
+		switch {
+		case !doc.IsValidRemotePath(pkg):
+			ps.State, ps.Reason = gcse.SubmitInvalid, "not a valid import path"
+		case submitDB.Get(pkg, &st) && !st.Time.Before(sub.Time):
+			ps.State, ps.Reason, ps.Updated = st.State, st.Reason, st.Time
+			if st.State == gcse.SubmitCrawled {
+				if indexUpdated.After(st.Time) && inIndex(pkg) {
+					ps.State = gcse.SubmitIndexed
+				} else {
+					ps.Reason = "waiting for the indexer"
+				}
+			}
+		default:
+			ps.Reason = "waiting for the crawler"
+		}
+		status.Packages = append(status.Packages, ps)
+	}
+	return status
+}
+
+func pageSubmission(w http.ResponseWriter, r *http.Request) {
+	sub, err := gcse.LoadSubmission(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		http.Error(w, "Submission not found!", http.StatusNotFound)
+		return
+	}
+	if err := templates.ExecuteTemplate(w, "submission.html",
+		statusOfSubmission(sub)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func apiSubmission(w http.ResponseWriter, r *http.Request, callback string) {
+	id := strings.TrimSpace(r.FormValue("id"))
+	sub, err := gcse.LoadSubmission(id)
+	if err != nil {
+		ApiContent(w, http.StatusNotFound,
+			fmt.Sprintf("Submission %s not found!", id), callback)
+		return
+	}
+	ApiContent(w, http.StatusOK, statusOfSubmission(sub), callback)
+}
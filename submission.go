@@ -0,0 +1,244 @@
+package gcse
+
+import (
+	"crypto/rand"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/daviddengcn/gddo/doc"
+	"github.com/daviddengcn/go-villa"
+)
+
+// States of a submitted package.
+const (
+	// waiting for the crawler
+	SubmitQueued = "queued"
+	// crawled, waiting for the indexer
+	SubmitCrawled = "crawled"
+	// crawling failed, may be retried later
+	SubmitFailed = "failed"
+	// not a valid import path, or not found
+	SubmitInvalid = "invalid"
+	// crawled and in the index
+	SubmitIndexed = "indexed"
+)
+
+// time a SubmitState is kept in CrawlerDB.SubmitDB
+const submitStateTTL = 30 * 24 * time.Hour
+
+// SubmitState is the state of a submitted package recorded by the crawler.
+type SubmitState struct {
+	State  string
+	Reason string
+	Time   time.Time
+}
+
+func init() {
+	gob.Register(SubmitState{})
+}
+
+// Submission is a list of packages submitted by a user at /add.
+type Submission struct {
+	ID       string
+	Time     time.Time
+	Packages []string
+}
+
+var patSubmissionID = regexp.MustCompile(`^[0-9]{8}-[0-9]{6}-[0-9a-f]{8}$`)
+
+func newSubmissionID(t time.Time) string {
+	var b [4]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%s-%x", t.UTC().Format("20060102-150405"), b)
+}
+
+func submissionFn(id string) villa.Path {
+	return SubmissionPath.Join(id + ".json")
+}
+
+// NewSubmission saves a Submission of packages and appends them for the
+// crawler by AppendPackages.
+func NewSubmission(pkgs []string) (*Submission, error) {
+	now := time.Now()
+	sub := &Submission{
+		ID:       newSubmissionID(now),
+		Time:     now,
+		Packages: pkgs,
+	}
+	if err := WriteJsonFile(submissionFn(sub.ID), sub); err != nil {
+		return nil, villa.NestErrorf(err, "NewSubmission")
+	}
+	if !AppendPackages(pkgs) {
+		return nil, fmt.Errorf("AppendPackages failed")
+	}
+	log.Printf("Submission %s of %d packages saved", sub.ID, len(pkgs))
+	return sub, nil
+}
+
+// LoadSubmission loads a Submission saved by NewSubmission.
+func LoadSubmission(id string) (*Submission, error) {
+	if !patSubmissionID.MatchString(id) {
+		return nil, fmt.Errorf("invalid submission id: %q", id)
+	}
+	var sub Submission
+	if err := ReadJsonFile(submissionFn(id), &sub); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// SetSubmitState records the state of a submitted package.
+func (cdb *CrawlerDB) SetSubmitState(pkg, state, reason string) {
+	cdb.SubmitDB.Put(pkg, SubmitState{
+		State:  state,
+		Reason: reason,
+		Time:   time.Now(),
+	})
+}
+
+// submitPending returns whether a package was submitted and the crawler has
+// not recorded a final state of it, i.e. it is queued or failed.
+func (cdb *CrawlerDB) submitPending(pkg string) bool {
+	var st SubmitState
+	return cdb.SubmitDB.Get(pkg, &st) &&
+		(st.State == SubmitQueued || st.State == SubmitFailed)
+}
+
+// SubmitCrawlResult records the state of a package after a crawl if it was
+// submitted, i.e. submitted is CrawlingEntry.Submitted or the state of a
+// previous crawl is pending. A failed submission is retried as other packages
+// with Submitted cleared, and its state is still updated by the retry.
+func (cdb *CrawlerDB) SubmitCrawlResult(pkg string, submitted bool,
+	state, reason string) {
+	if submitted || cdb.submitPending(pkg) {
+		cdb.SetSubmitState(pkg, state, reason)
+	}
+}
+
+// PurgeSubmitStates removes the SubmitStates older than submitStateTTL.
+// Returns the number of entries removed.
+func PurgeSubmitStates(db *MemDB) int {
+	var expired []string
+	before := time.Now().Add(-submitStateTTL)
+	db.Iterate(func(pkg string, val interface{}) error {
+		if st, ok := val.(SubmitState); !ok || st.Time.Before(before) {
+			expired = append(expired, pkg)
+		}
+		return nil
+	})
+	for _, pkg := range expired {
+		db.Delete(pkg)
+	}
+	return len(expired)
+}
+
+// hosts whose web URLs of files and directories are in the form of
+// <host>/<user>/<repo>/<kind>/<revision>/<path>, kind in the values
+var repoWebPaths = map[string][]string{
+	"github.com":    {"tree", "blob"},
+	"bitbucket.org": {"src"},
+}
+
+// NormalizeImportPath converts a package given by a user, e.g. a pasted URL
+// like https://github.com/user/repo/tree/master/dir, a git@ URL or a path
+// with a trailing .git, into its import path. Returns an error if the result
+// is not a valid remote import path.
+func NormalizeImportPath(s string) (string, error) {
+	pkg := strings.Trim(strings.TrimSpace(s), `"'`)
+	if pkg == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if p := strings.IndexAny(pkg, "?#"); p >= 0 {
+		pkg = pkg[:p]
+	}
+	if p := strings.Index(pkg, "://"); p >= 0 {
+		pkg = pkg[p+len("://"):]
+		// user info, e.g. git@ in ssh://git@github.com/user/repo
+		if at := strings.Index(pkg, "@"); at >= 0 &&
+			at < strings.IndexAny(pkg+"/", "/") {
+			pkg = pkg[at+1:]
+		}
+	} else if at := strings.Index(pkg, "@"); at >= 0 &&
+		strings.Contains(pkg[at:], ":") {
+		// scp-like syntax, e.g. git@github.com:user/repo.git
+		pkg = strings.Replace(pkg[at+1:], ":", "/", 1)
+	}
+	pkg = strings.TrimSuffix(strings.TrimRight(pkg, "/"), ".git")
+
+	parts := strings.Split(pkg, "/")
+	parts[0] = strings.ToLower(parts[0])
+	// drop the port, if any
+	if p := strings.Index(parts[0], ":"); p >= 0 {
+		parts[0] = parts[0][:p]
+	}
+	parts[0] = strings.TrimPrefix(parts[0], "www.")
+	if kinds, ok := repoWebPaths[parts[0]]; ok && len(parts) > 4 {
+		for _, kind := range kinds {
+			if parts[3] == kind {
+				// drop <kind>/<revision>
+				parts = append(parts[:3], parts[5:]...)
+				if kind == "blob" && len(parts) > 3 {
+					// a file, use its directory
+					parts = parts[:len(parts)-1]
+				}
+				break
+			}
+		}
+	}
+	pkg = strings.Join(parts, "/")
+	if !doc.IsValidRemotePath(pkg) {
+		return "", fmt.Errorf("%s is not a valid import path", pkg)
+	}
+	return pkg, nil
+}
+
+// RejectedPackage is an entry of a submission failed NormalizeImportPath.
+type RejectedPackage struct {
+	Input  string
+	Reason string
+}
+
+// NormalizeSubmission splits the text submitted at /add into entries by
+// lines and white spaces, ignoring "go get" commands and flags, normalizes
+// them by NormalizeImportPath and removes duplicates. Banned packages are
+// rejected.
+func NormalizeSubmission(text string) (accepted []string,
+	rejected []RejectedPackage) {
+	var seen villa.StrSet
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "go" && fields[1] == "get" {
+			fields = fields[2:]
+		}
+		for _, f := range fields {
+			if strings.HasPrefix(f, "-") {
+				continue
+			}
+			pkg, err := NormalizeImportPath(f)
+			if err != nil {
+				rejected = append(rejected, RejectedPackage{
+					Input:  f,
+					Reason: err.Error(),
+				})
+				continue
+			}
+			if IsBanned(pkg) {
+				rejected = append(rejected, RejectedPackage{
+					Input:  f,
+					Reason: pkg + " is banned",
+				})
+				continue
+			}
+			if seen.In(pkg) {
+				continue
+			}
+			seen.Put(pkg)
+			accepted = append(accepted, pkg)
+		}
+	}
+	return accepted, rejected
+}
@@ -0,0 +1,107 @@
+package gcse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/daviddengcn/go-assert"
+)
+
+func TestSubmissionID(t *testing.T) {
+	id := newSubmissionID(time.Date(2014, 3, 5, 6, 7, 8, 0, time.UTC))
+	assert.Equals(t, "prefix", id[:16], "20140305-060708-")
+	assert.IsTrue(t, "patSubmissionID", patSubmissionID.MatchString(id))
+	assert.IsFalse(t, "patSubmissionID",
+		patSubmissionID.MatchString("../../etc/passwd"))
+
+	_, err := LoadSubmission("../x")
+	assert.IsTrue(t, "LoadSubmission", err != nil)
+}
+
+func TestPurgeSubmitStates(t *testing.T) {
+	cdb := &CrawlerDB{SubmitDB: NewMemDB("", "")}
+	cdb.SetSubmitState("github.com/a/b", SubmitCrawled, "")
+	cdb.SubmitDB.Put("github.com/a/c", SubmitState{
+		State: SubmitFailed,
+		Time:  time.Now().Add(-submitStateTTL - time.Hour),
+	})
+
+	assert.Equals(t, "PurgeSubmitStates", PurgeSubmitStates(cdb.SubmitDB), 1)
+	var st SubmitState
+	assert.IsTrue(t, "Get", cdb.SubmitDB.Get("github.com/a/b", &st))
+	assert.Equals(t, "State", st.State, SubmitCrawled)
+}
+
+func TestSubmitCrawlResult(t *testing.T) {
+	cdb := &CrawlerDB{
+		PackageDB: NewMemDB("", ""),
+		SubmitDB:  NewMemDB("", ""),
+	}
+	pkg := "github.com/a/b"
+	state := func() string {
+		var st SubmitState
+		cdb.SubmitDB.Get(pkg, &st)
+		return st.State
+	}
+	cdb.SubmitPackage(pkg)
+	assert.Equals(t, "queued", state(), SubmitQueued)
+
+	// the first crawl fails and the package is retried as others
+	var ent CrawlingEntry
+	cdb.PackageDB.Get(pkg, &ent)
+	cdb.SubmitCrawlResult(pkg, ent.Submitted, SubmitFailed, "timeout")
+	cdb.RetryPackage(pkg, time.Now(), "")
+	assert.Equals(t, "failed", state(), SubmitFailed)
+
+	// the retry succeeds
+	cdb.PackageDB.Get(pkg, &ent)
+	assert.IsFalse(t, "Submitted", ent.Submitted)
+	cdb.SubmitCrawlResult(pkg, ent.Submitted, SubmitCrawled, "")
+	assert.Equals(t, "crawled", state(), SubmitCrawled)
+
+	// packages not submitted are not recorded
+	cdb.SubmitCrawlResult("github.com/a/c", false, SubmitCrawled, "")
+	assert.IsFalse(t, "not submitted",
+		cdb.SubmitDB.Get("github.com/a/c", &SubmitState{}))
+}
+
+func TestNormalizeImportPath(t *testing.T) {
+	for _, c := range []struct {
+		in, pkg string
+	}{
+		{"github.com/daviddengcn/gcse", "github.com/daviddengcn/gcse"},
+		{" https://github.com/daviddengcn/gcse/ ", "github.com/daviddengcn/gcse"},
+		{"https://www.GitHub.com/daviddengcn/gcse.git", "github.com/daviddengcn/gcse"},
+		{"git@github.com:daviddengcn/gcse.git", "github.com/daviddengcn/gcse"},
+		{"ssh://git@github.com/daviddengcn/gcse", "github.com/daviddengcn/gcse"},
+		{"https://github.com/daviddengcn/gcse/tree/master/server",
+			"github.com/daviddengcn/gcse/server"},
+		{"https://github.com/daviddengcn/gcse/blob/master/server/smain.go",
+			"github.com/daviddengcn/gcse/server"},
+		{"https://github.com/daviddengcn/gcse#readme", "github.com/daviddengcn/gcse"},
+		{"https://bitbucket.org/user/repo/src/default/sub",
+			"bitbucket.org/user/repo/sub"},
+		{`"golang.org/x/net/html"`, "golang.org/x/net/html"},
+	} {
+		pkg, err := NormalizeImportPath(c.in)
+		assert.NoErrorf(t, "NormalizeImportPath: %v", err)
+		assert.Equals(t, c.in, pkg, c.pkg)
+	}
+
+	for _, in := range []string{"", "gcse", "http://", "github.com/a b"} {
+		_, err := NormalizeImportPath(in)
+		assert.IsTrue(t, in, err != nil)
+	}
+}
+
+func TestNormalizeSubmission(t *testing.T) {
+	accepted, rejected := NormalizeSubmission(`
+go get -u github.com/daviddengcn/gcse
+https://github.com/daviddengcn/gcse.git
+github.com/daviddengcn/go-villa  not-a-path
+`)
+	assert.StringEquals(t, "accepted", accepted,
+		[]string{"github.com/daviddengcn/gcse", "github.com/daviddengcn/go-villa"})
+	assert.Equals(t, "len(rejected)", len(rejected), 1)
+	assert.Equals(t, "rejected", rejected[0].Input, "not-a-path")
+}
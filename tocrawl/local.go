@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/daviddengcn/gcse"
+)
+
+// touchLocalPackages schedules all packages in gcse.CrawlerLocalRoots to be
+// crawled now. Crawling an unchanged local package is cheap because its Etag
+// is kept.
+func touchLocalPackages() {
+	log.Printf("touchLocalPackages ...")
+
+	pkgs, err := gcse.LocalPackages()
+	if err != nil {
+		log.Printf("LocalPackages failed: %v", err)
+	}
+	log.Printf("%d local packages found!", len(pkgs))
+
+	for _, pkg := range pkgs {
+		var ent gcse.CrawlingEntry
+		cDB.PackageDB.Get(pkg, &ent)
+		cDB.SchedulePackage(pkg, time.Now(), ent.Etag)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/daviddengcn/gcse"
+	"github.com/daviddengcn/go-villa"
 	"github.com/daviddengcn/sophie"
 	"github.com/daviddengcn/sophie/kv"
 )
@@ -44,10 +45,18 @@ func loadPackageUpdateTimes(fpDocs sophie.FsPath) (map[string]time.Time, error)
 	return pkgUTs, nil
 }
 
+type crawlEntry struct {
+	id  string
+	ent gcse.CrawlingEntry
+}
+
+// generateCrawlEntries writes the due entries into one part for each host.
+// Entries in a part are ordered by gcse.CrawlBefore so that the most valuable
+// ones are crawled first before the crawler stops.
 func generateCrawlEntries(db *gcse.MemDB, hostFromID func(id string) string,
 	out kv.DirOutput) error {
 	now := time.Now()
-	groups := make(map[string]sophie.CollectCloser)
+	groups := make(map[string][]crawlEntry)
 	count := 0
 	if err := db.Iterate(func(id string, val interface{}) error {
 		ent, ok := val.(gcse.CrawlingEntry)
@@ -62,25 +71,35 @@ func generateCrawlEntries(db *gcse.MemDB, hostFromID func(id string) string,
 		}
 
 		host := hostFromID(id)
-		c, ok := groups[host]
-		if !ok {
-			index := len(groups)
-			var err error
-			c, err = out.Collector(index)
-			if err != nil {
-				return err
-			}
-			groups[host] = c
-		}
-
+		groups[host] = append(groups[host], crawlEntry{id, ent})
 		count++
-		return c.Collect(sophie.RawString(id), &ent)
+		return nil
 	}); err != nil {
 		return err
 	}
 
-	for _, c := range groups {
+	index := 0
+	for host, ents := range groups {
+		villa.SortF(len(ents), func(i, j int) bool {
+			return gcse.CrawlBefore(&ents[i].ent, &ents[j].ent)
+		}, func(i, j int) {
+			ents[i], ents[j] = ents[j], ents[i]
+		})
+
+		c, err := out.Collector(index)
+		if err != nil {
+			return err
+		}
+		index++
+		for i := range ents {
+			if err := c.Collect(sophie.RawString(ents[i].id),
+				&ents[i].ent); err != nil {
+				c.Close()
+				return err
+			}
+		}
 		c.Close()
+		log.Printf("%d entries to crawl for %s", len(ents), host)
 	}
 
 	log.Printf("%d entries to crawl for folder %v", count, out.Path)
@@ -102,6 +121,7 @@ func main() {
 	log.Println("Running tocrawl tool, to generate crawling list")
 	// Load CrawlerDB
 	cDB = gcse.LoadCrawlerDB()
+	gcse.LoadBanList(gcse.NewMemDB(gcse.CrawlerDBPath, gcse.KindBan))
 
 	if gcse.CrawlGithubUpdate || gcse.CrawlByGodocApi {
 		// load pkgUTs
@@ -132,6 +152,17 @@ func main() {
 		syncDatabases()
 	}
 
+	if len(gcse.CrawlerLocalRoots) > 0 {
+		touchLocalPackages()
+		syncDatabases()
+	}
+
+	// packages submitted since the last run of the crawler
+	if err := cDB.ProcessImports(); err != nil {
+		log.Printf("ProcessImports failed: %v", err)
+	}
+	syncDatabases()
+
 	log.Printf("Package DB: %d entries", cDB.PackageDB.Count())
 	log.Printf("Person DB: %d entries", cDB.PersonDB.Count())
 
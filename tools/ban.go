@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/daviddengcn/gcse"
+	"github.com/daviddengcn/go-villa"
+)
+
+func help() {
+	fmt.Fprintln(os.Stderr,
+		`Usage: ban list|add [-prefix] <path> <reason>|remove <path>
+  list    lists the banned packages and prefixes
+  add     bans a package, or all packages under the path with -prefix, which
+          are removed from the docs by the next run of mergedocs
+  remove  removes a path from the ban list`)
+}
+
+func listBans(db *gcse.MemDB) {
+	var paths []string
+	db.Iterate(func(path string, val interface{}) error {
+		paths = append(paths, path)
+		return nil
+	})
+	villa.SortF(len(paths), func(i, j int) bool {
+		return paths[i] < paths[j]
+	}, func(i, j int) {
+		paths[i], paths[j] = paths[j], paths[i]
+	})
+
+	for _, path := range paths {
+		var ent gcse.BanEntry
+		db.Get(path, &ent)
+		if ent.Prefix {
+			path += "/..."
+		}
+		fmt.Printf("%s\t%s\tadded %s\n", path, ent.Reason,
+			ent.Added.Format(time.RFC3339))
+	}
+	fmt.Printf("Total %d entries.\n", len(paths))
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		help()
+		return
+	}
+
+	db := gcse.NewMemDB(gcse.CrawlerDBPath, gcse.KindBan)
+	switch os.Args[1] {
+	case "list":
+		listBans(db)
+		return
+	case "add":
+		args := os.Args[2:]
+		prefix := len(args) > 0 && args[0] == "-prefix"
+		if prefix {
+			args = args[1:]
+		}
+		if len(args) < 2 {
+			help()
+			return
+		}
+		if err := gcse.BanPackage(db, args[0], prefix,
+			strings.Join(args[1:], " ")); err != nil {
+			log.Fatalf("BanPackage failed: %v", err)
+		}
+	case "remove":
+		if len(os.Args) < 3 {
+			help()
+			return
+		}
+		if !gcse.UnbanPackage(db, os.Args[2]) {
+			fmt.Printf("%s is not banned.\n", os.Args[2])
+			return
+		}
+	default:
+		help()
+		return
+	}
+
+	if err := db.Sync(); err != nil {
+		log.Fatalf("db.Sync() failed: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/daviddengcn/gcse"
+	"github.com/daviddengcn/go-villa"
+)
+
+func help() {
+	fmt.Fprintln(os.Stderr,
+		`Usage: blacklist list|clear [<url-prefix>]|purge
+  list    lists the blacklisted URLs
+  clear   removes the URLs with the prefix, or all URLs if not specified
+  purge   removes expired URLs
+Do not run it while the crawler is running.`)
+}
+
+func listBlack(db *gcse.MemDB) {
+	var urls []string
+	db.Iterate(func(u string, val interface{}) error {
+		urls = append(urls, u)
+		return nil
+	})
+	villa.SortF(len(urls), func(i, j int) bool {
+		return urls[i] < urls[j]
+	}, func(i, j int) {
+		urls[i], urls[j] = urls[j], urls[i]
+	})
+
+	now := time.Now()
+	for _, u := range urls {
+		var ent gcse.BlackEntry
+		db.Get(u, &ent)
+		state := "expires in " + ent.Expires.Sub(now).String()
+		if !now.Before(ent.Expires) {
+			state = "expired"
+		}
+		fmt.Printf("%s\t%s\tadded %s, %s\n", u, ent.Status,
+			ent.Added.Format(time.RFC3339), state)
+	}
+	fmt.Printf("Total %d URLs.\n", len(urls))
+}
+
+func clearBlack(db *gcse.MemDB, prefix string) {
+	var urls []string
+	db.Iterate(func(u string, val interface{}) error {
+		if strings.HasPrefix(u, prefix) {
+			urls = append(urls, u)
+		}
+		return nil
+	})
+	for _, u := range urls {
+		db.Delete(u)
+	}
+	fmt.Printf("%d URLs removed.\n", len(urls))
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		help()
+		return
+	}
+
+	db := gcse.NewMemDB(gcse.CrawlerDBPath, gcse.KindBlack)
+	switch os.Args[1] {
+	case "list":
+		listBlack(db)
+		return
+	case "clear":
+		prefix := ""
+		if len(os.Args) > 2 {
+			prefix = os.Args[2]
+		}
+		clearBlack(db, prefix)
+	case "purge":
+		fmt.Printf("%d URLs removed.\n", gcse.PurgeBlacklist(db))
+	default:
+		help()
+		return
+	}
+
+	if err := db.Sync(); err != nil {
+		log.Fatalf("db.Sync() failed: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/daviddengcn/gcse"
+)
+
+var (
+	topN       = flag.Int("n", 20, "number of top queries to show")
+	positions  = flag.Int("positions", 10, "number of positions to show CTR")
+	withUndone = flag.Bool("undone", false,
+		"include the segment being written by the server")
+)
+
+func main() {
+	flag.Parse()
+
+	segms, err := gcse.QueryLogSegments.ListAll()
+	if err != nil {
+		log.Fatalf("ListAll failed: %v", err)
+	}
+	stats := gcse.NewQueryStats()
+	cnt := 0
+	for _, segm := range segms {
+		if !segm.IsDone() && !*withUndone {
+			continue
+		}
+		if err := gcse.ReadQueryLog(segm, func(ent *gcse.QueryLogEntry) error {
+			stats.Add(ent)
+			return nil
+		}); err != nil {
+			log.Printf("ReadQueryLog %v failed: %v", segm, err)
+			continue
+		}
+		cnt++
+	}
+	fmt.Printf("%d segments, %d searches, %d clicks\n", cnt, stats.Searches,
+		stats.Clicks)
+
+	fmt.Printf("\nTop queries:\n")
+	for _, qc := range gcse.TopQueries(stats.Queries, *topN) {
+		fmt.Printf("%8d  %s\n", qc.Count, qc.Query)
+	}
+
+	fmt.Printf("\nTop zero-result queries:\n")
+	for _, qc := range gcse.TopQueries(stats.ZeroResults, *topN) {
+		fmt.Printf("%8d  %s\n", qc.Count, qc.Query)
+	}
+
+	fmt.Printf("\nClick-through rate per position:\n")
+	for pos := 1; pos <= *positions && pos <= len(stats.Impressions); pos++ {
+		clicks := 0
+		if pos <= len(stats.PositionClicks) {
+			clicks = stats.PositionClicks[pos-1]
+		}
+		fmt.Printf("%4d  %6.2f%%  (%d/%d)\n", pos, stats.CTR(pos)*100, clicks,
+			stats.Impressions[pos-1])
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/daviddengcn/gcse"
+	"github.com/daviddengcn/go-villa"
+)
+
+var (
+	epochs = flag.Int("epochs", 1000, "number of epochs of training")
+	rate   = flag.Float64("rate", 0.5, "learning rate")
+	l2     = flag.Float64("l2", 0.01,
+		"L2 regularization towards the default scorer")
+	minPairs = flag.Int("min_pairs", 100, "minimum number of pairs to train")
+	out      = flag.String("o", "",
+		"file to save the model, web.rank_model if not specified")
+)
+
+func main() {
+	flag.Parse()
+
+	segms, err := gcse.QueryLogSegments.ListDones()
+	if err != nil {
+		log.Fatalf("ListDones failed: %v", err)
+	}
+	villa.SortF(len(segms), func(i, j int) bool {
+		return gcse.SegmentLess(segms[i], segms[j])
+	}, func(i, j int) {
+		segms[i], segms[j] = segms[j], segms[i]
+	})
+
+	c := gcse.NewRankPairCollector()
+	for _, segm := range segms {
+		if err := gcse.ReadQueryLog(segm, func(ent *gcse.QueryLogEntry) error {
+			c.Add(ent)
+			return nil
+		}); err != nil {
+			log.Printf("ReadQueryLog %v failed: %v", segm, err)
+		}
+	}
+	c.Finish()
+	log.Printf("%d pairs collected from %d segments", len(c.Pairs), len(segms))
+	if len(c.Pairs) < *minPairs {
+		log.Fatalf("Too few pairs to train, at least %d needed", *minPairs)
+	}
+
+	m := gcse.TrainRankModel(c.Pairs, gcse.RankTrainOptions{
+		Epochs: *epochs,
+		Rate:   *rate,
+		L2:     *l2,
+	})
+	for i, name := range m.Features {
+		fmt.Printf("%-20s %8.4f\n", name, m.Weights[i]/m.Std[i])
+	}
+
+	fn := gcse.ServerRankModel
+	if *out != "" {
+		fn = villa.Path(*out)
+	}
+	if err := gcse.SaveRankModel(fn, m); err != nil {
+		log.Fatalf("SaveRankModel failed: %v", err)
+	}
+	fmt.Printf("Model saved to %v, set web.scorer to \"model\" to use it.\n",
+		fn)
+}
@@ -0,0 +1,248 @@
+package gcse
+
+import (
+	"encoding/gob"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daviddengcn/gddo/doc"
+	"github.com/daviddengcn/go-villa"
+)
+
+// time a resolved VanityRepo is cached in CrawlerDB.VanityDB
+const vanityTTL = 30 * 24 * time.Hour
+
+// hosts of which import paths are not custom
+var codeHosts = villa.NewStrSet("github.com", "bitbucket.org",
+	"code.google.com", "launchpad.net", "labix.org")
+
+// VanityRepo is the repository of a custom import path resolved from the
+// go-import meta tag of https://<import-path>?go-get=1.
+type VanityRepo struct {
+	// import path prefix of the repository
+	ImportPrefix string
+	VCS          string
+	// "" if no go-import meta tag is found
+	RepoURL string
+	// home URL in the go-source meta tag, "" if not specified
+	Home     string
+	Resolved time.Time
+}
+
+func init() {
+	gob.Register(VanityRepo{})
+}
+
+// RepoPath returns the repository URL without the scheme and the VCS suffix,
+// e.g. github.com/bazil/fuse for https://github.com/bazil/fuse.git.
+func (r *VanityRepo) RepoPath() string {
+	u, err := url.Parse(r.RepoURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return strings.TrimSuffix(u.Host+strings.TrimSuffix(u.Path, "/"),
+		"."+r.VCS)
+}
+
+// IsVanityPackage returns whether the import path of a package is a custom
+// one, i.e. not on a known code hosting site.
+func IsVanityPackage(pkg string) bool {
+	host := strings.SplitN(pkg, "/", 2)[0]
+	return strings.Contains(host, ".") && !codeHosts.In(host)
+}
+
+type metaTag struct {
+	name   string
+	fields []string
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// parseGoMetaTags returns the go-import and go-source meta tags in the head
+// of an HTML page. The page is parsed by a non-strict XML decoder as
+// cmd/go does.
+func parseGoMetaTags(r io.Reader) (tags []metaTag, err error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader,
+		error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "ascii":
+			return input, nil
+		}
+		return nil, fmt.Errorf("can't decode XHTML in charset %q", charset)
+	}
+	d.Strict = false
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(tags) > 0 {
+				err = nil
+			}
+			return tags, err
+		}
+		if e, ok := t.(xml.StartElement); ok &&
+			strings.EqualFold(e.Name.Local, "body") {
+			return tags, nil
+		}
+		if e, ok := t.(xml.EndElement); ok &&
+			strings.EqualFold(e.Name.Local, "head") {
+			return tags, nil
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+		name := attrValue(e.Attr, "name")
+		if name != "go-import" && name != "go-source" {
+			continue
+		}
+		tags = append(tags, metaTag{
+			name:   name,
+			fields: strings.Fields(attrValue(e.Attr, "content")),
+		})
+	}
+}
+
+// hasPathPrefix returns whether prefix is pkg or a parent path of it.
+func hasPathPrefix(pkg, prefix string) bool {
+	return pkg == prefix || strings.HasPrefix(pkg, prefix+"/")
+}
+
+// vanityRepoOfTags returns the VanityRepo of pkg in the meta tags. Returns
+// nil if no go-import tag matches pkg.
+func vanityRepoOfTags(pkg string, tags []metaTag) *VanityRepo {
+	var repo *VanityRepo
+	for _, tag := range tags {
+		if tag.name != "go-import" || len(tag.fields) != 3 ||
+			!hasPathPrefix(pkg, tag.fields[0]) {
+			continue
+		}
+		repo = &VanityRepo{
+			ImportPrefix: tag.fields[0],
+			VCS:          tag.fields[1],
+			RepoURL:      tag.fields[2],
+		}
+		break
+	}
+	if repo == nil {
+		return nil
+	}
+	for _, tag := range tags {
+		if tag.name == "go-source" && len(tag.fields) >= 2 &&
+			tag.fields[0] == repo.ImportPrefix {
+			repo.Home = tag.fields[1]
+			break
+		}
+	}
+	return repo
+}
+
+// ResolveVanity fetches https://<pkg>?go-get=1 and returns the repository of
+// the go-import meta tag matching pkg. Returns nil if not found.
+func ResolveVanity(httpClient doc.HttpClient, pkg string) (*VanityRepo,
+	error) {
+	resp, err := httpGet(httpClient, "https://"+pkg+"?go-get=1")
+	if err != nil {
+		return nil, villa.NestErrorf(err, "ResolveVanity(%s)", pkg)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("ResolveVanity(%s): %s", pkg, resp.Status)
+	}
+
+	tags, err := parseGoMetaTags(resp.Body)
+	if err != nil {
+		return nil, villa.NestErrorf(err, "ResolveVanity(%s)", pkg)
+	}
+	return vanityRepoOfTags(pkg, tags), nil
+}
+
+// registered vanity repositories, import prefix -> repo path
+var vanityRepos struct {
+	sync.RWMutex
+	m map[string]string
+}
+
+// RegisterVanityRepo makes AuthorOfPackage, ProjectOfPackage and
+// FullProjectOfPackage aware of a VanityRepo.
+func RegisterVanityRepo(repo *VanityRepo) {
+	repoPath := repo.RepoPath()
+	if repoPath == "" {
+		return
+	}
+	vanityRepos.Lock()
+	defer vanityRepos.Unlock()
+
+	if vanityRepos.m == nil {
+		vanityRepos.m = make(map[string]string)
+	}
+	vanityRepos.m[repo.ImportPrefix] = repoPath
+}
+
+// LoadVanityRepos registers all VanityRepos in a db, e.g.
+// CrawlerDB.VanityDB.
+func LoadVanityRepos(db *MemDB) {
+	cnt := 0
+	db.Iterate(func(_ string, val interface{}) error {
+		if repo, ok := val.(VanityRepo); ok && repo.RepoURL != "" {
+			RegisterVanityRepo(&repo)
+			cnt++
+		}
+		return nil
+	})
+	log.Printf("%d vanity repositories loaded", cnt)
+}
+
+// vanityPrefixes returns pkg and its parent paths, longest first.
+func vanityPrefixes(pkg string) []string {
+	var prefixes []string
+	for p := pkg; ; {
+		prefixes = append(prefixes, p)
+		idx := strings.LastIndex(p, "/")
+		if idx < 0 {
+			return prefixes
+		}
+		p = p[:idx]
+	}
+}
+
+// vanityRepoOf returns the import prefix and the repo path of the registered
+// VanityRepo of a package. Returns "", "" if not found.
+func vanityRepoOf(pkg string) (prefix, repoPath string) {
+	vanityRepos.RLock()
+	defer vanityRepos.RUnlock()
+
+	if len(vanityRepos.m) == 0 {
+		return "", ""
+	}
+	for _, p := range vanityPrefixes(pkg) {
+		if repoPath, ok := vanityRepos.m[p]; ok {
+			return p, repoPath
+		}
+	}
+	return "", ""
+}
+
+// repoPathOfPackage returns the import path of a package as if it were
+// imported from its repository, e.g. github.com/bazil/fuse/fs for
+// bazil.org/fuse/fs. Returns pkg if it is not in a registered VanityRepo.
+func repoPathOfPackage(pkg string) string {
+	prefix, repoPath := vanityRepoOf(pkg)
+	if prefix == "" {
+		return pkg
+	}
+	return repoPath + pkg[len(prefix):]
+}
@@ -0,0 +1,69 @@
+package gcse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daviddengcn/go-assert"
+)
+
+func TestParseGoMetaTags(t *testing.T) {
+	tags, err := parseGoMetaTags(strings.NewReader(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<meta name="go-import" content="bazil.org/fuse git https://github.com/bazil/fuse">
+<meta name="go-source" content="bazil.org/fuse https://github.com/bazil/fuse https://github.com/bazil/fuse/tree/master{/dir} https://github.com/bazil/fuse/blob/master{/dir}/{file}#L{line}">
+</head>
+<body>
+<meta name="go-import" content="bazil.org/other git https://example.com/other">
+</body>
+</html>`))
+	assert.NoErrorf(t, "parseGoMetaTags: %v", err)
+	assert.Equals(t, "len(tags)", len(tags), 2)
+
+	repo := vanityRepoOfTags("bazil.org/fuse/fs", tags)
+	assert.IsTrue(t, "repo != nil", repo != nil)
+	assert.Equals(t, "ImportPrefix", repo.ImportPrefix, "bazil.org/fuse")
+	assert.Equals(t, "VCS", repo.VCS, "git")
+	assert.Equals(t, "Home", repo.Home, "https://github.com/bazil/fuse")
+	assert.Equals(t, "RepoPath", repo.RepoPath(), "github.com/bazil/fuse")
+
+	assert.IsTrue(t, "bazil.org/fusex",
+		vanityRepoOfTags("bazil.org/fusex", tags) == nil)
+}
+
+func TestVanityRepo_RepoPath(t *testing.T) {
+	repo := VanityRepo{VCS: "git", RepoURL: "https://example.com/a/b.git"}
+	assert.Equals(t, "RepoPath", repo.RepoPath(), "example.com/a/b")
+	repo = VanityRepo{VCS: "hg", RepoURL: ""}
+	assert.Equals(t, "RepoPath", repo.RepoPath(), "")
+}
+
+func TestIsVanityPackage(t *testing.T) {
+	assert.IsTrue(t, "bazil.org/fuse", IsVanityPackage("bazil.org/fuse"))
+	assert.IsFalse(t, "github.com/daviddengcn/gcse",
+		IsVanityPackage("github.com/daviddengcn/gcse"))
+	assert.IsFalse(t, "fmt", IsVanityPackage("fmt"))
+}
+
+func TestVanityProjectOfPackage(t *testing.T) {
+	defer func(m map[string]string) { vanityRepos.m = m }(vanityRepos.m)
+	vanityRepos.m = nil
+
+	RegisterVanityRepo(&VanityRepo{
+		ImportPrefix: "bazil.org/fuse",
+		VCS:          "git",
+		RepoURL:      "https://github.com/bazil/fuse",
+	})
+	pkg := "bazil.org/fuse/fs/fstestutil"
+	assert.Equals(t, "AuthorOfPackage", AuthorOfPackage(pkg), "bazil")
+	assert.Equals(t, "ProjectOfPackage", ProjectOfPackage(pkg), "fuse")
+	assert.Equals(t, "FullProjectOfPackage", FullProjectOfPackage(pkg),
+		"bazil.org/fuse")
+
+	pkg = "bazil.org/fusex/a/b"
+	assert.Equals(t, "AuthorOfPackage", AuthorOfPackage(pkg), "bazil.org")
+	assert.Equals(t, "FullProjectOfPackage", FullProjectOfPackage(pkg),
+		"bazil.org/fusex/a")
+}